@@ -64,10 +64,23 @@ func (nc *networkCard) listenNormal() {
 				return
 			}
 
+			// deal greeting packet, it create session before client auth
+			if nc.isExpectedGreeting(dstIP, tcpPkt) {
+				nc.parseTCPPackage(srcIP, dstIP, tcpPkt, nil)
+				return
+			}
+
+			// deal PROXY protocol header, it is not mysql data and never counted as client data
+			if authHeader, headerLen := readProxyHeader(tcpPkt.Payload); authHeader != nil {
+				tcpPkt.Payload = tcpPkt.Payload[headerLen:]
+				tcpPkt.Seq += uint32(headerLen)
+				nc.parseTCPPackage(srcIP, dstIP, tcpPkt, authHeader)
+				return
+			}
+
 			// deal auth packet
 			if sd.IsAuthPacket(tcpPkt.Payload) {
-				authHeader, _ := pp.Read(bufio.NewReader(bytes.NewReader(tcpPkt.Payload)))
-				nc.parseTCPPackage(srcIP, dstIP, tcpPkt, authHeader)
+				nc.parseTCPPackage(srcIP, dstIP, tcpPkt, nil)
 				return
 			}
 
@@ -109,7 +122,7 @@ func (nc *networkCard) parseTCPPackage(srcIP, dstIP string, tcpPkt *layers.TCP,
 	var err error
 	defer func() {
 		if err != nil {
-			log.Error("parse TCP package failed <-- %s", err.Error())
+			log.Errorf("parse TCP package failed <-- %s", err.Error())
 		}
 	}()
 
@@ -142,7 +155,7 @@ func (nc *networkCard) parseTCPPackage(srcIP, dstIP string, tcpPkt *layers.TCP,
 
 	} else if srcPort == nc.listenPort {
 		// deal mysql client request
-		err = readFromServerPackage(&dstIP, dstPort, tcpPkt)
+		err = readFromServerPackage(&dstIP, dstPort, &srcIP, tcpPkt, nc.receiver)
 		if err != nil {
 			return
 		}
@@ -151,11 +164,46 @@ func (nc *networkCard) parseTCPPackage(srcIP, dstIP string, tcpPkt *layers.TCP,
 	return
 }
 
+// readProxyHeader parse PROXY protocol header at the beginning of payload, return nil if there is none,
+// headerLen is the bytes of header, mysql packets follow it
+func readProxyHeader(payload []byte) (header *pp.Header, headerLen int) {
+	if len(payload) < 1 || (payload[0] != pp.SIGV1[0] && payload[0] != pp.SIGV2[0]) {
+		return
+	}
+
+	reader := bytes.NewReader(payload)
+	bufReader := bufio.NewReader(reader)
+	header, err := pp.Read(bufReader)
+	if err != nil {
+		return nil, 0
+	}
+	headerLen = len(payload) - reader.Len() - bufReader.Buffered()
+	return
+}
+
+// isExpectedGreeting check if server packet is the greeting of session waiting for it,
+// which is captured without sampling
+func (nc *networkCard) isExpectedGreeting(dstIP string, tcpPkt *layers.TCP) bool {
+	if int(tcpPkt.SrcPort) != nc.listenPort {
+		return false
+	}
+
+	sessionKey := spliceSessionKey(&dstIP, int(tcpPkt.DstPort))
+	return isGreeting(sessionPool[*sessionKey], tcpPkt.Payload)
+}
+
+// isGreeting check if payload is server greeting, only before client send any data,
+// later packet like it may be result row
+func isGreeting(session sd.ConnSession, payload []byte) bool {
+	return (session == nil || session.ExpectGreeting()) && sd.IsGreetingPacket(payload)
+}
+
 func readFromServerPackage(
-	clientIP *string, clientPort int, tcpPkt *layers.TCP) (err error) {
+	clientIP *string, clientPort int, srcIP *string, tcpPkt *layers.TCP,
+	receiver chan model.QueryPiece) (err error) {
 	defer func() {
 		if err != nil {
-			log.Error("read Mysql package send from mysql server to client failed <-- %s", err.Error())
+			log.Errorf("read Mysql package send from mysql server to client failed <-- %s", err.Error())
 		}
 	}()
 
@@ -176,6 +224,12 @@ func readFromServerPackage(
 
 	sessionKey := spliceSessionKey(clientIP, clientPort)
 	session := sessionPool[*sessionKey]
+	// server send greeting before client send any data
+	if session == nil && isGreeting(nil, tcpPayload) {
+		session = sd.NewSession(sessionKey, clientIP, clientPort, srcIP, snifferPort, receiver)
+		sessionPool[*sessionKey] = session
+	}
+
 	if session != nil {
 		pkt := model.NewTCPPacket(tcpPayload, int64(tcpPkt.Ack), false)
 		session.ReceiveTCPPacket(pkt)
@@ -189,7 +243,7 @@ func readToServerPackage(
 	receiver chan model.QueryPiece) (err error) {
 	defer func() {
 		if err != nil {
-			log.Error("read package send from client to mysql server failed <-- %s", err.Error())
+			log.Errorf("read package send from client to mysql server failed <-- %s", err.Error())
 		}
 	}()
 
@@ -0,0 +1,50 @@
+package capture
+
+import (
+	"net"
+	"testing"
+
+	pp "github.com/pires/go-proxyproto"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	v2Header, err := (&pp.Header{
+		Version:            2,
+		Command:            pp.PROXY,
+		TransportProtocol:  pp.TCPv4,
+		SourceAddress:      net.ParseIP("10.1.1.1"),
+		DestinationAddress: net.ParseIP("10.2.2.2"),
+		SourcePort:         51000,
+		DestinationPort:    3306,
+	}).Format()
+	if err != nil {
+		t.Fatalf("format proxy protocol v2 header failed <-- %s", err.Error())
+	}
+	v1Header := []byte("PROXY TCP4 10.1.1.1 10.2.2.2 51000 3306\r\n")
+	authPacket := []byte{0x05, 0, 0, 1, 0x85, 0xa6, 0xff, 0x01, 0}
+
+	cases := []struct {
+		name      string
+		payload   []byte
+		client    string
+		headerLen int
+	}{
+		{"v1 header only", v1Header, "10.1.1.1", len(v1Header)},
+		{"v1 header with auth packet", append(append([]byte{}, v1Header...), authPacket...), "10.1.1.1", len(v1Header)},
+		{"v2 header only", v2Header, "10.1.1.1", len(v2Header)},
+		{"mysql packet", authPacket, "", 0},
+		{"broken header", []byte("PROXY TCP4 10.1.1.1"), "", 0},
+		{"empty", nil, "", 0},
+	}
+
+	for _, c := range cases {
+		header, headerLen := readProxyHeader(c.payload)
+		client := ""
+		if header != nil {
+			client = header.SourceAddress.String()
+		}
+		if client != c.client || headerLen != c.headerLen {
+			t.Errorf("%s: got client %q header length %d, want %q %d", c.name, client, headerLen, c.client, c.headerLen)
+		}
+	}
+}
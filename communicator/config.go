@@ -11,6 +11,8 @@ import (
 const (
 	CAPTURE_PACKET_RATE = "capture_packet_rate"
 	QPS = "qps"
+	LONG_QUERY_TIME = "long_query_time"
+	FAST_QUERIES_PER_DIGEST = "fast_queries_per_digest"
 )
 
 var (
@@ -23,13 +25,19 @@ var (
 	configMap         map[string]configItem
 	catpurePacketRate *capturePacketRateConfig
 	catpurePacketRateVal float64
+	longQueryTime     *longQueryTimeConfig
+	fastQueriesPerDigest *fastQueriesPerDigestConfig
 )
 
 func init() {
 	catpurePacketRate = newCapturePacketRateConfig()
+	longQueryTime = &longQueryTimeConfig{}
+	fastQueriesPerDigest = &fastQueriesPerDigestConfig{}
 
 	flag.IntVar(&communicatePort, "communicate_port", 8088, "http server port. Default is 8088")
 	flag.Float64Var(&catpurePacketRateVal, CAPTURE_PACKET_RATE, 1.0, "capture packet rate. Default is 1.0")
+	flag.Float64Var(&longQueryTime.seconds, LONG_QUERY_TIME, 0, "only output query take seconds not less than it, 0 means output all queries. Default is 0")
+	flag.Int64Var(&fastQueriesPerDigest.count, FAST_QUERIES_PER_DIGEST, 0, "output first queries of each digest per minute even if faster than long_query_time. Default is 0")
 
 	configMap = make(map[string]configItem)
 	regsiterConfig()
@@ -38,4 +46,6 @@ func init() {
 func regsiterConfig()  {
 	configMap[CAPTURE_PACKET_RATE] = catpurePacketRate
 	configMap[QPS] = &qpsConfig{}
+	configMap[LONG_QUERY_TIME] = longQueryTime
+	configMap[FAST_QUERIES_PER_DIGEST] = fastQueriesPerDigest
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	hu "github.com/zr-hebo/util-http"
@@ -26,6 +27,7 @@ func Server() {
 
 func initConfig()  {
 	_ = catpurePacketRate.setVal(catpurePacketRateVal)
+	_ = longQueryTime.setVal(longQueryTime.seconds)
 }
 
 func outletCheckAlive(resp http.ResponseWriter, req *http.Request) {
@@ -94,4 +96,14 @@ func GetTCPCapturePacketRate() float64 {
 
 func GetMysqlCapturePacketRate() float64 {
 	return catpurePacketRate.mysqlCPR
-}
\ No newline at end of file
+}
+
+// GetLongQueryTimeInMS return long query time in milliseconds, 0 means all queries are output
+func GetLongQueryTimeInMS() int64 {
+	return atomic.LoadInt64(&longQueryTime.ms)
+}
+
+// GetFastQueriesPerDigest return count of fast queries output for each digest per minute
+func GetFastQueriesPerDigest() int64 {
+	return atomic.LoadInt64(&fastQueriesPerDigest.count)
+}
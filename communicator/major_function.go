@@ -25,3 +25,11 @@ func GetConfig(key string) (val interface{}) {
 	config := configMap[key]
 	return config.getVal()
 }
+
+// RegisterConfig register config which can be get and set at runtime by config key(name)
+func RegisterConfig(key string, getVal func() interface{}, setVal func(interface{}) error) {
+	configMapLock.Lock()
+	defer configMapLock.Unlock()
+
+	configMap[key] = &funcConfig{getter: getVal, setter: setVal}
+}
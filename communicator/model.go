@@ -3,6 +3,7 @@ package communicator
 import (
 	"fmt"
 	"math"
+	"sync/atomic"
 )
 
 type configItem interface {
@@ -40,4 +41,62 @@ func (cprc *capturePacketRateConfig) setVal (val interface{}) (err error){
 
 func (cprc *capturePacketRateConfig) getVal () (val interface{}){
 	return cprc.mysqlCPR
-}
\ No newline at end of file
+}
+
+// funcConfig is config item registered by other packages
+type funcConfig struct {
+	getter func() interface{}
+	setter func(interface{}) error
+}
+
+func (fc *funcConfig) setVal(val interface{}) (err error) {
+	return fc.setter(val)
+}
+
+func (fc *funcConfig) getVal() (val interface{}) {
+	return fc.getter()
+}
+
+// longQueryTimeConfig is the threshold of query time, faster query is not output,
+// seconds is set by flag and read atomically after set
+type longQueryTimeConfig struct {
+	seconds float64
+	// ms is the threshold in milliseconds, updated atomically
+	ms int64
+}
+
+func (lqtc *longQueryTimeConfig) setVal(val interface{}) (err error) {
+	realVal, ok := val.(float64)
+	if !ok || realVal < 0 {
+		err = fmt.Errorf("cannot transform val: %v to non-negative seconds", val)
+		return
+	}
+
+	atomic.StoreInt64(&lqtc.ms, int64(math.Ceil(realVal*1000)))
+	return
+}
+
+func (lqtc *longQueryTimeConfig) getVal() (val interface{}) {
+	return float64(atomic.LoadInt64(&lqtc.ms)) / 1000
+}
+
+// fastQueriesPerDigestConfig is the count of queries faster than long query time
+// output for each digest per minute
+type fastQueriesPerDigestConfig struct {
+	count int64
+}
+
+func (fqpdc *fastQueriesPerDigestConfig) setVal(val interface{}) (err error) {
+	realVal, ok := val.(float64)
+	if !ok || realVal < 0 || realVal != math.Floor(realVal) {
+		err = fmt.Errorf("cannot transform val: %v to non-negative integer", val)
+		return
+	}
+
+	atomic.StoreInt64(&fqpdc.count, int64(realVal))
+	return
+}
+
+func (fqpdc *fastQueriesPerDigestConfig) getVal() (val interface{}) {
+	return atomic.LoadInt64(&fqpdc.count)
+}
@@ -15,6 +15,7 @@ var (
 	kafkaGroupID string
 	asyncTopic string
 	syncTopic string
+	riskTopic string
 	compress string
     compressType sarama.CompressionCodec
 )
@@ -31,6 +32,9 @@ func init() {
 	flag.StringVar(
 		&syncTopic,
 		"kafka-sync-topic", "", "kafka sync send topic. No default value")
+	flag.StringVar(
+		&riskTopic,
+		"kafka-risk-topic", "", "kafka topic risky statements are sent to synchronously besides sync topic. No default value")
 	flag.StringVar(
 		&compress,
 		"compress-type", "", "kafka message compress type. Default value is no compress")
@@ -41,6 +45,7 @@ type kafkaExporter struct {
 	syncProducer  sarama.SyncProducer
 	asyncTopic  string
 	syncTopic  string
+	riskTopic  string
 }
 
 func checkParams()  {
@@ -91,6 +96,7 @@ func NewKafkaExporter() (ke *kafkaExporter) {
 	ke.asyncProducer = asyncProducer
 	ke.asyncTopic = asyncTopic
 	ke.syncTopic = syncTopic
+	ke.riskTopic = riskTopic
 
 	go func() {
 		errors := ke.asyncProducer.Errors()
@@ -116,6 +122,17 @@ func (ke *kafkaExporter) Export (qp model.QueryPiece) (err error){
 		}
 	}()
 
+	if qp.NeedRiskSend() && len(ke.riskTopic) > 0 {
+		msg := &sarama.ProducerMessage {
+			Topic: ke.riskTopic,
+			Value: sarama.ByteEncoder(qp.Bytes()),
+		}
+		_, _, err = ke.syncProducer.SendMessage(msg)
+		if err != nil {
+			return
+		}
+	}
+
 	if qp.NeedSyncSend() {
 		// log.Debugf("deal ddl: %s\n", *qp.String())
 
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"encoding/json"
+
 	"github.com/pingcap/tidb/util/hack"
 )
 
@@ -15,7 +17,98 @@ type MysqlQueryPiece struct {
 	VisitUser    *string `json:"user"`
 	VisitDB      *string `json:"db"`
 	QuerySQL     *string `json:"sql"`
+	Command      string  `json:"command,omitempty"`
 	CostTimeInMS int64   `json:"cms"`
+
+	// Fingerprint is sql with literals replaced, Digest is its 64 bits hash in hex
+	Fingerprint *string `json:"fingerprint,omitempty"`
+	Digest      string  `json:"digest,omitempty"`
+
+	// StmtType is statement type classified by leading words, ReadOnly means statement does not write data
+	StmtType string `json:"stmt_type,omitempty"`
+	ReadOnly *bool  `json:"read_only,omitempty"`
+
+	// Tables is the tables referenced by statement, it is shared by pieces of same digest
+	Tables []TableAccess `json:"tables,omitempty"`
+
+	// Redacted means literals or params are masked
+	Redacted bool `json:"redacted,omitempty"`
+
+	// Risk is the IDs of risk rules statement matched
+	Risk []string `json:"risk,omitempty"`
+
+	ServerVersion *string `json:"server_version,omitempty"`
+	ConnectionID  uint32  `json:"connection_id,omitempty"`
+	AuthPlugin    *string `json:"auth_plugin,omitempty"`
+
+	// ConnAttrs is encoded json object, it is same for all queries in session
+	ConnAttrs json.RawMessage `json:"conn_attrs,omitempty"`
+
+	Params          []interface{} `json:"params,omitempty"`
+	InterpolatedSQL *string       `json:"interpolated_sql,omitempty"`
+	// RequestedRows is the rows count client requested by COM_STMT_FETCH, rows returned are in results
+	RequestedRows *int64 `json:"requested_rows,omitempty"`
+	// StmtID is the prepared statement id, CursorBT is the begin time of the execution opened
+	// the cursor which COM_STMT_FETCH read rows from, fetches can be joined to the execution by them
+	StmtID   *int  `json:"stmt_id,omitempty"`
+	CursorBT int64 `json:"cursor_bt,omitempty"`
+
+	Truncated bool   `json:"truncated,omitempty"`
+	SQLLength *int64 `json:"sql_length,omitempty"`
+
+	Results    []MysqlResult `json:"results,omitempty"`
+	Statements []string      `json:"statements,omitempty"`
+
+	InfileBytes *int64 `json:"infile_bytes,omitempty"`
+
+	Binlog *BinlogInfo `json:"binlog,omitempty"`
+
+	TrxID       int64            `json:"trx_id,omitempty"`
+	Transaction *TransactionInfo `json:"transaction,omitempty"`
+
+	// SessionVars is same for queries in session until variables changed
+	SessionVars *SessionVars `json:"session_vars,omitempty"`
+}
+
+// TableAccess 语句访问的表，access是read或write，未指定库名的表使用会话的当前库
+type TableAccess struct {
+	DB     string `json:"db,omitempty"`
+	Table  string `json:"table"`
+	Access string `json:"access"`
+}
+
+// SessionVars 会话中跟踪的变量，未知的变量不输出
+type SessionVars struct {
+	Autocommit  string `json:"autocommit,omitempty"`
+	SQLMode     string `json:"sql_mode,omitempty"`
+	TimeZone    string `json:"time_zone,omitempty"`
+	TxIsolation string `json:"transaction_isolation,omitempty"`
+}
+
+// TransactionInfo 事务结束时输出的事务统计，idle_ms是事务中语句之间的空闲时间
+type TransactionInfo struct {
+	Statements int    `json:"statements"`
+	IdleMS     int64  `json:"idle_ms"`
+	Outcome    string `json:"outcome"`
+}
+
+// BinlogInfo 复制和CDC客户端注册和订阅binlog的信息，以及binlog流量
+type BinlogInfo struct {
+	ServerID       uint32 `json:"replica_server_id"`
+	ReportHost     string `json:"report_host,omitempty"`
+	ReportPort     int    `json:"report_port,omitempty"`
+	File           string `json:"binlog_file,omitempty"`
+	Pos            uint64 `json:"binlog_pos,omitempty"`
+	GTIDSet        string `json:"gtid_set,omitempty"`
+	StreamBytes    int64  `json:"stream_bytes,omitempty"`
+	BytesPerSecond int64  `json:"bytes_per_second,omitempty"`
+}
+
+// MysqlResult 服务端返回的一个结果，OK包记录影响行数，结果集记录行数，ERR包记录错误码
+type MysqlResult struct {
+	AffectedRows *int64 `json:"affected_rows,omitempty"`
+	Rows         *int64 `json:"rows,omitempty"`
+	ErrorCode    *int   `json:"error_code,omitempty"`
 }
 
 func (mqp *MysqlQueryPiece) String() (*string) {
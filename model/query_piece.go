@@ -13,12 +13,14 @@ type QueryPiece interface {
 	Bytes() []byte
 	GetSQL() *string
 	NeedSyncSend() bool
+	NeedRiskSend() bool
 	Recovery()
 }
 
 // BaseQueryPiece 查询信息
 type BaseQueryPiece struct {
 	SyncSend          bool    `json:"-"`
+	RiskSend          bool    `json:"-"`
 	ServerIP          *string `json:"sip"`
 	ServerPort        int     `json:"sport"`
 	CapturePacketRate float64 `json:"cpr"`
@@ -43,6 +45,7 @@ func NewBaseQueryPiece(
 	bqp.ServerIP = serverIP
 	bqp.ServerPort = serverPort
 	bqp.SyncSend = false
+	bqp.RiskSend = false
 	bqp.CapturePacketRate = capturePacketRate
 	bqp.EventTime = time.Now().UnixNano() / millSecondUnit
 
@@ -57,6 +60,15 @@ func (bqp *BaseQueryPiece) SetNeedSyncSend(syncSend bool) {
 	bqp.SyncSend = syncSend
 }
 
+// NeedRiskSend return true if query piece is risky statement, it is sent to risk destination
+func (bqp *BaseQueryPiece) NeedRiskSend() (bool) {
+	return bqp.RiskSend
+}
+
+func (bqp *BaseQueryPiece) SetNeedRiskSend(riskSend bool) {
+	bqp.RiskSend = riskSend
+}
+
 func (bqp *BaseQueryPiece) String() (*string) {
 	content := bqp.Bytes()
 	contentStr := hack.String(content)
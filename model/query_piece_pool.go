@@ -24,9 +24,31 @@ func NewPooledMysqlQueryPiece(
 	pmqp.VisitUser = visitUser
 	pmqp.VisitDB = visitDB
 	pmqp.SyncSend = false
+	pmqp.RiskSend = false
 	pmqp.CapturePacketRate = throwPacketRate
 	pmqp.EventTime = stmtBeginTimeNano / millSecondUnit
 	pmqp.CostTimeInMS = (time.Now().UnixNano() - stmtBeginTimeNano) / millSecondUnit
+	pmqp.Command = ""
+	pmqp.Fingerprint = nil
+	pmqp.Digest = ""
+	pmqp.StmtType = ""
+	pmqp.ReadOnly = nil
+	pmqp.Tables = nil
+	pmqp.Redacted = false
+	pmqp.Risk = nil
+	pmqp.Params = nil
+	pmqp.InterpolatedSQL = nil
+	pmqp.RequestedRows = nil
+	pmqp.StmtID = nil
+	pmqp.CursorBT = 0
+	pmqp.Truncated = false
+	pmqp.SQLLength = nil
+	pmqp.Results = nil
+	pmqp.Statements = nil
+	pmqp.InfileBytes = nil
+	pmqp.Binlog = nil
+	pmqp.TrxID = 0
+	pmqp.Transaction = nil
 	pmqp.recoverPool = mqpp
 
 	return
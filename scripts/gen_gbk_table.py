@@ -0,0 +1,79 @@
+# generate GBK double byte decode table and GB18030 four byte ranges of BMP
+# for session-dealer/mysql/charset_gbk_table.go
+# usage: python3 scripts/gen_gbk_table.py > session-dealer/mysql/charset_gbk_table.go
+
+
+def _decode(lead, trail):
+    try:
+        ch = bytes([lead, trail]).decode('gbk')
+    except UnicodeDecodeError:
+        return 0
+
+    if len(ch) != 1 or ord(ch) > 0xffff:
+        return 0
+    return ord(ch)
+
+
+def _decode_four_byte(idx):
+    b4, idx = idx % 10, idx // 10
+    b3, idx = idx % 126, idx // 126
+    b2, b1 = idx % 10, idx // 10
+    try:
+        ch = bytes([b1 + 0x81, b2 + 0x30, b3 + 0x81, b4 + 0x30]).decode('gb18030')
+    except UnicodeDecodeError:
+        return 0
+
+    if len(ch) != 1 or ord(ch) > 0xffff:
+        return 0
+    return ord(ch)
+
+
+def _four_byte_ranges():
+    ranges = []
+    prev = -1
+    idx = 0
+    while True:
+        code_point = _decode_four_byte(idx)
+        if code_point == 0:
+            return ranges, idx
+        if code_point != prev + 1:
+            ranges.append((idx, code_point))
+        prev = code_point
+        idx += 1
+
+
+def _real_main():
+    values = []
+    for lead in range(0x81, 0xff):
+        for trail in range(0x40, 0xff):
+            if trail == 0x7f:
+                continue
+            values.append(_decode(lead, trail))
+
+    print('// Code generated by scripts/gen_gbk_table.py; DO NOT EDIT.')
+    print('')
+    print('package mysql')
+    print('')
+    print('// gbkDecodeTable map GBK double byte character to unicode code point, 0 means undecodable.')
+    print('// Index is (lead-0x81)*190 + (trail-0x40), minus 1 if trail is bigger than 0x7f')
+    print('var gbkDecodeTable = [%d]uint16{' % len(values))
+    for idx in range(0, len(values), 12):
+        print('\t' + ' '.join('0x%04x,' % val for val in values[idx:idx + 12]))
+    print('}')
+
+    ranges, limit = _four_byte_ranges()
+    print('')
+    print('// gb18030FourByteLimit is the linear index of first GB18030 four byte character beyond BMP')
+    print('const gb18030FourByteLimit = %d' % limit)
+    print('')
+    print('// gb18030FourByteRanges map GB18030 four byte character in BMP to unicode code point,')
+    print('// each pair is the linear index and code point of the first one in a continuous range.')
+    print('// Linear index is ((b1-0x81)*10+(b2-0x30))*1260 + (b3-0x81)*10 + (b4-0x30)')
+    print('var gb18030FourByteRanges = [%d][2]uint16{' % len(ranges))
+    for idx in range(0, len(ranges), 6):
+        print('\t' + ' '.join('{0x%04x, 0x%04x},' % val for val in ranges[idx:idx + 6]))
+    print('}')
+
+
+if __name__ == '__main__':
+    _real_main()
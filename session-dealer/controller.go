@@ -34,3 +34,13 @@ func IsAuthPacket(payload []byte) bool {
 		return false
 	}
 }
+
+func IsGreetingPacket(payload []byte) bool {
+	switch serviceType {
+	case ServiceTypeMysql:
+		return len(payload) >= 5 && mysql.IsGreeting(payload[:4], payload[4])
+
+	default:
+		return false
+	}
+}
@@ -4,5 +4,7 @@ import "github.com/zr-hebo/sniffer-agent/model"
 
 type ConnSession interface {
 	ReceiveTCPPacket(*model.TCPPacket)
+	// ExpectGreeting check if session wait for server greeting, client has sent nothing
+	ExpectGreeting() bool
 	Close()
 }
@@ -0,0 +1,326 @@
+package mysql
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// adminTLSConfigName is the name of TLS config registered to mysql driver
+	adminTLSConfigName = "sniffer-admin"
+	adminRetryMinDelay = time.Second
+	adminRetryMaxDelay = time.Minute
+)
+
+var (
+	errAdminConnBackoff = errors.New("admin connection is failed, wait to retry")
+	// adminTargets is the admin connection target by sniffed server ip:port or port, key "" is for all servers
+	adminTargets map[string]*adminTarget
+	adminTLS     string
+)
+
+// adminTarget is the address admin connection dial, addr without port means the sniffed port
+type adminTarget struct {
+	network string
+	addr    string
+}
+
+// parseAdminTargets parse targets split by comma, every target is [[server_ip:]server_port=]address,
+// address is unix socket path, host or host:port
+func parseAdminTargets(targets string) (parsed map[string]*adminTarget, err error) {
+	parsed = make(map[string]*adminTarget)
+	for _, entry := range strings.Split(targets, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) < 1 {
+			continue
+		}
+
+		server := ""
+		if idx := strings.Index(entry, "="); idx > 0 {
+			server, err = parseAdminServer(entry[:idx])
+			if err != nil {
+				return nil, fmt.Errorf("invalid server in admin target %s", entry)
+			}
+			entry = entry[idx+1:]
+		}
+
+		target := &adminTarget{network: "tcp", addr: entry}
+		if strings.HasPrefix(entry, "unix:") {
+			target.network = "unix"
+			target.addr = entry[len("unix:"):]
+		} else if strings.HasPrefix(entry, "/") {
+			target.network = "unix"
+		}
+		if len(target.addr) < 1 {
+			return nil, fmt.Errorf("empty address in admin target %s", entry)
+		}
+		parsed[server] = target
+	}
+	return
+}
+
+// parseAdminServer return key of sniffed server in admin target, ip:port or port only
+func parseAdminServer(server string) (key string, err error) {
+	if !strings.Contains(server, ":") {
+		var port int
+		port, err = strconv.Atoi(server)
+		return strconv.Itoa(port), err
+	}
+
+	host, portStr, err := net.SplitHostPort(server)
+	if err != nil {
+		return
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return
+	}
+	return adminServerKey(host, port), nil
+}
+
+// adminServerKey return ip:port of sniffed server, which is the key of admin target and connection pool
+func adminServerKey(serverIP string, port int) string {
+	return net.JoinHostPort(serverIP, strconv.Itoa(port))
+}
+
+// adminConfig return driver config of admin connection to sniffed server,
+// target of server ip:port is preferred to the one of port only
+func adminConfig(serverIP string, port int) (cfg *mysql.Config) {
+	cfg = mysql.NewConfig()
+	cfg.User = adminUser
+	cfg.Passwd = adminPasswd
+	cfg.Net = "tcp"
+	cfg.Addr = fmt.Sprintf("localhost:%d", port)
+	cfg.Timeout = time.Second
+	cfg.ReadTimeout = 5 * time.Second
+	cfg.TLSConfig = adminTLS
+
+	target := adminTargets[adminServerKey(serverIP, port)]
+	if target == nil {
+		target = adminTargets[strconv.Itoa(port)]
+	}
+	if target == nil {
+		target = adminTargets[""]
+	}
+	if target == nil {
+		return
+	}
+
+	cfg.Net = target.network
+	cfg.Addr = target.addr
+	if target.network == "tcp" && !strings.Contains(target.addr, ":") {
+		cfg.Addr = fmt.Sprintf("%s:%d", target.addr, port)
+	}
+	return
+}
+
+// adminRetryDelay return backoff delay after continuous failures
+func adminRetryDelay(failures uint) (delay time.Duration) {
+	delay = adminRetryMaxDelay
+	if failures <= 6 {
+		delay = adminRetryMinDelay << (failures - 1)
+	}
+	if delay > adminRetryMaxDelay {
+		delay = adminRetryMaxDelay
+	}
+	return
+}
+
+// isAdminConnBroken check if query error is caused by connection, rather than by sql
+func isAdminConnBroken(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, isServerErr := err.(*mysql.MySQLError)
+	return !isServerErr
+}
+
+// loadAdminCredentials fill admin user and passwd not set by flags, from passwd file,
+// environment variable and login file in order
+func loadAdminCredentials() (err error) {
+	if len(adminPasswd) < 1 && len(adminPasswdFile) > 0 {
+		var content []byte
+		content, err = readPrivateFile(adminPasswdFile)
+		if err != nil {
+			return
+		}
+		adminPasswd = strings.TrimRight(string(content), "\r\n")
+	}
+
+	if len(adminPasswd) < 1 && len(adminPasswdEnv) > 0 {
+		adminPasswd = os.Getenv(adminPasswdEnv)
+	}
+
+	if (len(adminUser) < 1 || len(adminPasswd) < 1) && len(adminLoginFile) > 0 {
+		var content []byte
+		content, err = readPrivateFile(adminLoginFile)
+		if err != nil {
+			return
+		}
+		content, err = decryptLoginFile(content)
+		if err != nil {
+			return fmt.Errorf("decrypt login file %s failed <-- %s", adminLoginFile, err.Error())
+		}
+
+		user, passwd := parseLoginPath(content, adminLoginPath)
+		if len(adminUser) < 1 {
+			adminUser = user
+		}
+		if len(adminPasswd) < 1 {
+			adminPasswd = passwd
+		}
+	}
+	return
+}
+
+// readPrivateFile read file with credentials, which cannot be accessed by group and others
+func readPrivateFile(path string) (content []byte, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("file %s is accessible by others, its mode must be 0600", path)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// decryptLoginFile decrypt login file created by mysql_config_editor, plain text
+// option file is returned as it is
+// https://dev.mysql.com/doc/refman/8.0/en/mysql-config-editor.html
+func decryptLoginFile(content []byte) (plain []byte, err error) {
+	if len(bytes.TrimSpace(content)) > 0 && bytes.TrimSpace(content)[0] == '[' {
+		return content, nil
+	}
+
+	// 4 bytes unused, 20 bytes key, then encrypted lines with 4 bytes length ahead
+	if len(content) < 24 {
+		return nil, ErrMalformPacket
+	}
+	key := make([]byte, aes.BlockSize)
+	for i, b := range content[4:24] {
+		key[i%aes.BlockSize] ^= b
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+
+	var buffer bytes.Buffer
+	offset := 24
+	for offset+4 <= len(content) {
+		lineLen := int(binary.LittleEndian.Uint32(content[offset : offset+4]))
+		offset += 4
+		if lineLen%aes.BlockSize != 0 || offset+lineLen > len(content) {
+			return nil, ErrMalformPacket
+		}
+
+		// AES-128 in ECB mode
+		line := make([]byte, lineLen)
+		for i := 0; i < lineLen; i += aes.BlockSize {
+			block.Decrypt(line[i:i+aes.BlockSize], content[offset+i:offset+i+aes.BlockSize])
+		}
+		offset += lineLen
+
+		if lineLen > 0 {
+			padding := int(line[lineLen-1])
+			if padding > aes.BlockSize || padding > lineLen {
+				return nil, ErrMalformPacket
+			}
+			line = line[:lineLen-padding]
+		}
+		buffer.Write(line)
+	}
+	return buffer.Bytes(), nil
+}
+
+// parseLoginPath get user and password in section of login path
+func parseLoginPath(content []byte, loginPath string) (user, passwd string) {
+	inSection := false
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) < 1 || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			inSection = strings.TrimSpace(strings.Trim(line, "[]")) == loginPath
+			continue
+		}
+		if !inSection {
+			continue
+		}
+
+		idx := strings.Index(line, "=")
+		if idx < 0 {
+			continue
+		}
+		name := strings.TrimSpace(line[:idx])
+		value := strings.TrimSpace(line[idx+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		switch name {
+		case "user":
+			user = value
+		case "password":
+			passwd = value
+		}
+	}
+	return
+}
+
+// registerAdminTLS register TLS config of admin connection to mysql driver, and
+// return the config name used in dsn
+func registerAdminTLS() (name string, err error) {
+	mode := strings.ToLower(adminTLSMode)
+	if len(adminTLSCA) < 1 && len(adminTLSCert) < 1 {
+		switch mode {
+		case "", "false":
+			return "", nil
+		case "true", "skip-verify":
+			return mode, nil
+		default:
+			return "", fmt.Errorf("unknown admin tls mode %s", adminTLSMode)
+		}
+	}
+
+	// tls is enabled if ca or cert is set
+	config := &tls.Config{InsecureSkipVerify: mode == "skip-verify"}
+	if len(adminTLSCA) > 0 {
+		var pem []byte
+		pem, err = ioutil.ReadFile(adminTLSCA)
+		if err != nil {
+			return
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return "", fmt.Errorf("no certificate found in %s", adminTLSCA)
+		}
+	}
+	if len(adminTLSCert) > 0 {
+		var cert tls.Certificate
+		cert, err = tls.LoadX509KeyPair(adminTLSCert, adminTLSKey)
+		if err != nil {
+			return
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	err = mysql.RegisterTLSConfig(adminTLSConfigName, config)
+	return adminTLSConfigName, err
+}
@@ -0,0 +1,118 @@
+package mysql
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestParseAdminTargets(t *testing.T) {
+	cases := []struct {
+		spec    string
+		targets map[string]*adminTarget
+		err     bool
+	}{
+		{"", map[string]*adminTarget{}, false},
+		{"/tmp/mysql.sock", map[string]*adminTarget{"": {"unix", "/tmp/mysql.sock"}}, false},
+		{"3306=unix:/var/lib/mysql/mysql.sock, 3307=127.0.0.1:3307,db.local",
+			map[string]*adminTarget{
+				"3306": {"unix", "/var/lib/mysql/mysql.sock"},
+				"3307": {"tcp", "127.0.0.1:3307"},
+				"":     {"tcp", "db.local"},
+			}, false},
+		{"10.0.0.1:3306=/data/a.sock,10.0.0.2:3306=10.0.0.2,[fe80::1]:03306=/data/c.sock",
+			map[string]*adminTarget{
+				"10.0.0.1:3306":  {"unix", "/data/a.sock"},
+				"10.0.0.2:3306":  {"tcp", "10.0.0.2"},
+				"[fe80::1]:3306": {"unix", "/data/c.sock"},
+			}, false},
+		{"port=/tmp/mysql.sock", nil, true},
+		{"10.0.0.1:port=/tmp/mysql.sock", nil, true},
+		{"3306=unix:", nil, true},
+	}
+
+	for _, c := range cases {
+		targets, err := parseAdminTargets(c.spec)
+		if (err != nil) != c.err || !reflect.DeepEqual(targets, c.targets) {
+			t.Errorf("parse %q got %v %v, want %v error %v", c.spec, targets, err, c.targets, c.err)
+		}
+	}
+}
+
+func TestAdminConfig(t *testing.T) {
+	defer func(targets map[string]*adminTarget) {
+		adminTargets = targets
+	}(adminTargets)
+	adminTargets, _ = parseAdminTargets("10.0.0.1:3306=/data/a.sock,3306=10.0.0.9,3307=db.local:3310")
+
+	cases := []struct {
+		serverIP string
+		port     int
+		network  string
+		addr     string
+	}{
+		{"10.0.0.1", 3306, "unix", "/data/a.sock"},
+		{"10.0.0.2", 3306, "tcp", "10.0.0.9:3306"},
+		{"10.0.0.1", 3307, "tcp", "db.local:3310"},
+		{"10.0.0.1", 3308, "tcp", "localhost:3308"},
+	}
+
+	for _, c := range cases {
+		cfg := adminConfig(c.serverIP, c.port)
+		if cfg.Net != c.network || cfg.Addr != c.addr {
+			t.Errorf("server %s:%d got %s %s, want %s %s", c.serverIP, c.port, cfg.Net, cfg.Addr, c.network, c.addr)
+		}
+	}
+}
+
+// encryptLoginFile compose login file in the format of mysql_config_editor
+func encryptLoginFile(key []byte, lines ...string) (content []byte) {
+	content = append([]byte{0, 0, 0, 0}, key...)
+	cipherKey := make([]byte, aes.BlockSize)
+	for i, b := range key {
+		cipherKey[i%aes.BlockSize] ^= b
+	}
+	block, _ := aes.NewCipher(cipherKey)
+
+	for _, line := range lines {
+		padding := aes.BlockSize - len(line)%aes.BlockSize
+		plain := append([]byte(line), bytes.Repeat([]byte{byte(padding)}, padding)...)
+		encrypted := make([]byte, len(plain))
+		for i := 0; i < len(plain); i += aes.BlockSize {
+			block.Encrypt(encrypted[i:i+aes.BlockSize], plain[i:i+aes.BlockSize])
+		}
+		content = binary.LittleEndian.AppendUint32(content, uint32(len(encrypted)))
+		content = append(content, encrypted...)
+	}
+	return
+}
+
+func TestDecryptLoginFile(t *testing.T) {
+	key := []byte("0123456789abcdefghij")
+	lines := []string{"[client]\n", "user = \"sniffer\"\n", "password = \"p@ss word\"\n", "[backup]\n", "user = bk\n"}
+	plain, err := decryptLoginFile(encryptLoginFile(key, lines...))
+	if err != nil || string(plain) != "[client]\nuser = \"sniffer\"\npassword = \"p@ss word\"\n[backup]\nuser = bk\n" {
+		t.Fatalf("got %q %v", plain, err)
+	}
+	if user, passwd := parseLoginPath(plain, "client"); user != "sniffer" || passwd != "p@ss word" {
+		t.Errorf("login path client got %q %q", user, passwd)
+	}
+	if user, passwd := parseLoginPath(plain, "backup"); user != "bk" || passwd != "" {
+		t.Errorf("login path backup got %q %q", user, passwd)
+	}
+
+	// plain text option file is returned as it is
+	optionFile := []byte("\n[client]\nuser=root\n")
+	if plain, err = decryptLoginFile(optionFile); err != nil || !bytes.Equal(plain, optionFile) {
+		t.Errorf("plain option file got %q %v", plain, err)
+	}
+
+	encrypted := encryptLoginFile(key, lines...)
+	for _, content := range [][]byte{encrypted[:20], encrypted[:len(encrypted)-3]} {
+		if _, err = decryptLoginFile(content); err != ErrMalformPacket {
+			t.Errorf("truncated login file of %d bytes got error %v, want %v", len(content), err, ErrMalformPacket)
+		}
+	}
+}
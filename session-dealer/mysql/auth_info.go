@@ -1,19 +1,106 @@
 package mysql
 
-// parseAuthInfo parse username, dbname from mysql client auth info
-func parseAuthInfo(data []byte) (userName, dbName string, err error) {
-	var resp handshakeResponse41
-	pos, err := parseHandshakeResponseHeader(&resp, data)
+import (
+	"bytes"
+	"encoding/binary"
+
+	log "github.com/golang/glog"
+)
+
+// parseAuthInfo parse username, dbname and capability from mysql client auth info
+func parseAuthInfo(data []byte) (resp *handshakeResponse41, err error) {
+	resp = new(handshakeResponse41)
+	pos, err := parseHandshakeResponseHeader(resp, data)
 	if err != nil {
 		return
 	}
 
 	// Read the remaining part of the packet.
-	if err = parseHandshakeResponseBody(&resp, data, pos); err != nil {
+	if err = parseHandshakeResponseBody(resp, data, pos); err != nil {
 		return
 	}
 
-	userName = resp.User
-	dbName = resp.DBName
 	return
 }
+
+// isHandshakeResponse check if the cached client packet is handshake response
+func (ms *MysqlSession) isHandshakeResponse() bool {
+	if len(ms.cachedStmtBytes) < 1 {
+		return false
+	}
+
+	return ms.phase == phaseHandshake || (ms.phase == phaseCommand && IsAuth(ms.cachedStmtBytes[0]))
+}
+
+// readHandshakeResponse parse client handshake response and turn into auth phase,
+// or ssl phase if the client request ssl
+func (ms *MysqlSession) readHandshakeResponse() {
+	defer ms.clear()
+
+	// SSLRequest only contains the common header of handshake response
+	if len(ms.cachedStmtBytes) == 32 &&
+		binary.LittleEndian.Uint32(ms.cachedStmtBytes[:4])&ClientSSL > 0 {
+		log.Infof("session %s use ssl, ignore it", *ms.connectionID)
+		ms.phase = phaseSSL
+		return
+	}
+
+	resp, err := parseAuthInfo(ms.cachedStmtBytes)
+	if err != nil {
+		log.Errorf("parse auth info failed <-- %s", err.Error())
+		return
+	}
+	ms.visitUser = &resp.User
+	ms.visitDB = &resp.DBName
+	ms.capability = resp.Capability
+	ms.collation = resp.Collation
+	ms.charset = getCollationCharset(resp.Collation)
+	ms.setConnAttrs(resp.Attrs)
+	if len(resp.AuthPlugin) > 0 {
+		ms.authPlugin = &resp.AuthPlugin
+	}
+	ms.phase = phaseAuth
+}
+
+// readAuthResponse follow server packets in auth exchange, the exchange may be
+// several round trips with AuthSwitchRequest and AuthMoreData, until server send OK or ERR
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase.html
+func (ms *MysqlSession) readAuthResponse(data []byte) {
+	eachMysqlPacket(data, func(payload []byte) bool {
+		if len(payload) < 1 {
+			return true
+		}
+
+		switch payload[0] {
+		case OKHeader:
+			ms.phase = phaseCommand
+			if ms.pendingChangeUser != nil {
+				ms.applyChangeUser(ms.pendingChangeUser)
+				ms.pendingChangeUser = nil
+				ms.sendTrxSummary()
+			}
+			return false
+
+		case ErrHeader:
+			log.Infof("session %s auth failed", *ms.connectionID)
+			ms.phase = phaseCommand
+			ms.pendingChangeUser = nil
+			return false
+
+		case AuthSwitchRequest:
+			// plugin name(string[NUL]) and auth data
+			idx := bytes.IndexByte(payload[1:], 0)
+			if idx < 0 {
+				idx = len(payload) - 1
+			}
+			authPlugin := string(payload[1 : 1+idx])
+			ms.authPlugin = &authPlugin
+			ms.phase = phaseAuth
+
+		case AuthMoreDataHeader:
+			// caching_sha2_password fast auth result, public key, or sha256_password data
+			ms.phase = phaseAuth
+		}
+		return true
+	})
+}
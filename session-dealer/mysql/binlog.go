@@ -0,0 +1,163 @@
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+// binlogThroughGTID is the flag of COM_BINLOG_DUMP_GTID, means GTID set is sent
+const binlogThroughGTID uint16 = 0x04
+
+// binlogStream is the binlog events send to replica or CDC client after COM_BINLOG_DUMP,
+// it never end until connection closed, so only the bytes are counted
+type binlogStream struct {
+	command string
+	info    model.BinlogInfo
+	// bytes is the stream bytes since last report
+	bytes      int64
+	reportNano int64
+}
+
+func newBinlogStream(command byte, info *model.BinlogInfo) *binlogStream {
+	return &binlogStream{
+		command:    commandName(command),
+		info:       *info,
+		reportNano: time.Now().UnixNano(),
+	}
+}
+
+// readBinlogStream count binlog events, and report the stream load periodically
+func (ms *MysqlSession) readBinlogStream(payload []byte) {
+	ms.binlogStream.bytes += int64(len(payload))
+	if time.Now().UnixNano()-ms.binlogStream.reportNano >= int64(binlogStatInterval)*int64(time.Second) {
+		ms.sendQueryPiece(ms.reportBinlogStream())
+	}
+}
+
+// reportBinlogStream generate query piece of stream bytes since last report,
+// its begin time is the last report time and cost time is the report period
+func (ms *MysqlSession) reportBinlogStream() (mqp *model.PooledMysqlQueryPiece) {
+	stream := ms.binlogStream
+	ms.stmtBeginTimeNano = stream.reportNano
+	mqp = ms.composeQueryPiece()
+	streamSQL := "binlog stream"
+	mqp.QuerySQL = &streamSQL
+	mqp.Command = stream.command
+
+	info := stream.info
+	info.StreamBytes = stream.bytes
+	period := time.Now().UnixNano() - stream.reportNano
+	if period > 0 {
+		info.BytesPerSecond = stream.bytes * int64(time.Second) / period
+	}
+	mqp.Binlog = &info
+
+	stream.bytes = 0
+	stream.reportNano = time.Now().UnixNano()
+	return
+}
+
+// parseRegisterSlave parse COM_REGISTER_SLAVE payload without command byte
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_register_slave.html
+func parseRegisterSlave(data []byte) (info *model.BinlogInfo, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = ErrMalformPacket
+		}
+	}()
+
+	// limit capacity, so truncated packet never read the stale bytes beyond it
+	data = data[:len(data):len(data)]
+	info = new(model.BinlogInfo)
+	info.ServerID = binary.LittleEndian.Uint32(data[:4])
+	offset := 4
+	hostLen := int(data[offset])
+	info.ReportHost = string(data[offset+1 : offset+1+hostLen])
+	offset += 1 + hostLen
+	// skip user and password
+	offset += 1 + int(data[offset])
+	offset += 1 + int(data[offset])
+	info.ReportPort = int(binary.LittleEndian.Uint16(data[offset : offset+2]))
+	return
+}
+
+// parseBinlogDump parse COM_BINLOG_DUMP payload without command byte
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_binlog_dump.html
+func parseBinlogDump(data []byte) (info *model.BinlogInfo, err error) {
+	if len(data) < 10 {
+		return nil, ErrMalformPacket
+	}
+
+	info = new(model.BinlogInfo)
+	info.Pos = uint64(binary.LittleEndian.Uint32(data[:4]))
+	info.ServerID = binary.LittleEndian.Uint32(data[6:10])
+	info.File = string(bytes.TrimRight(data[10:], "\x00"))
+	return
+}
+
+// parseBinlogDumpGTID parse COM_BINLOG_DUMP_GTID payload without command byte
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_binlog_dump_gtid.html
+func parseBinlogDumpGTID(data []byte) (info *model.BinlogInfo, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = ErrMalformPacket
+		}
+	}()
+
+	// limit capacity, so truncated packet never read the stale bytes beyond it
+	data = data[:len(data):len(data)]
+	info = new(model.BinlogInfo)
+	flags := binary.LittleEndian.Uint16(data[:2])
+	info.ServerID = binary.LittleEndian.Uint32(data[2:6])
+	nameLen := int(binary.LittleEndian.Uint32(data[6:10]))
+	offset := 10
+	info.File = string(data[offset : offset+nameLen])
+	offset += nameLen
+	info.Pos = binary.LittleEndian.Uint64(data[offset : offset+8])
+	offset += 8
+
+	if flags&binlogThroughGTID > 0 {
+		dataLen := int(binary.LittleEndian.Uint32(data[offset : offset+4]))
+		offset += 4
+		info.GTIDSet = decodeGTIDSet(data[offset : offset+dataLen])
+	}
+	return
+}
+
+// decodeGTIDSet convert encoded GTID set to text like uuid:1-5:7
+func decodeGTIDSet(data []byte) string {
+	var buffer bytes.Buffer
+	sidCount := binary.LittleEndian.Uint64(data[:8])
+	offset := 8
+	for i := uint64(0); i < sidCount; i++ {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		sid := hex.EncodeToString(data[offset : offset+16])
+		buffer.WriteString(fmt.Sprintf("%s-%s-%s-%s-%s", sid[:8], sid[8:12], sid[12:16], sid[16:20], sid[20:]))
+		offset += 16
+
+		intervalCount := binary.LittleEndian.Uint64(data[offset : offset+8])
+		offset += 8
+		for j := uint64(0); j < intervalCount; j++ {
+			start := binary.LittleEndian.Uint64(data[offset : offset+8])
+			// end of interval is exclusive
+			end := binary.LittleEndian.Uint64(data[offset+8:offset+16]) - 1
+			offset += 16
+
+			buffer.WriteByte(':')
+			buffer.WriteString(strconv.FormatUint(start, 10))
+			if end > start {
+				buffer.WriteByte('-')
+				buffer.WriteString(strconv.FormatUint(end, 10))
+			}
+		}
+	}
+	return buffer.String()
+}
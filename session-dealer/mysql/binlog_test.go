@@ -0,0 +1,131 @@
+package mysql
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+// gtidSetData encode GTID set of one sid with intervals, end of interval is exclusive
+func gtidSetData(sid []byte, intervals ...[2]uint64) (data []byte) {
+	data = binary.LittleEndian.AppendUint64(data, 1)
+	data = append(data, sid...)
+	data = binary.LittleEndian.AppendUint64(data, uint64(len(intervals)))
+	for _, interval := range intervals {
+		data = binary.LittleEndian.AppendUint64(data, interval[0])
+		data = binary.LittleEndian.AppendUint64(data, interval[1])
+	}
+	return
+}
+
+// binlogDumpGTIDData compose COM_BINLOG_DUMP_GTID payload without command byte
+func binlogDumpGTIDData(serverID uint32, file string, pos uint64, gtidSet []byte) (data []byte) {
+	flags := uint16(0)
+	if gtidSet != nil {
+		flags = binlogThroughGTID
+	}
+	data = binary.LittleEndian.AppendUint16(data, flags)
+	data = binary.LittleEndian.AppendUint32(data, serverID)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(file)))
+	data = append(data, file...)
+	data = binary.LittleEndian.AppendUint64(data, pos)
+	if gtidSet != nil {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(gtidSet)))
+		data = append(data, gtidSet...)
+	}
+	return
+}
+
+func TestParseRegisterSlave(t *testing.T) {
+	data := []byte{0x65, 0, 0, 0, 5, 'r', 'e', 'p', 'l', '1', 4, 'r', 'e', 'p', 'l', 0, 0xea, 0x0c, 0, 0, 0, 0, 0, 0, 0, 0}
+	info, err := parseRegisterSlave(data)
+	want := &model.BinlogInfo{ServerID: 101, ReportHost: "repl1", ReportPort: 3306}
+	if err != nil || !reflect.DeepEqual(info, want) {
+		t.Errorf("got %+v %v, want %+v", info, err, want)
+	}
+
+	if _, err = parseRegisterSlave(data[:8]); err != ErrMalformPacket {
+		t.Errorf("truncated packet got error %v, want %v", err, ErrMalformPacket)
+	}
+}
+
+func TestParseBinlogDump(t *testing.T) {
+	data := append([]byte{0x04, 0x01, 0, 0, 0, 0, 0x66, 0, 0, 0}, "mysql-bin.000003"...)
+	info, err := parseBinlogDump(data)
+	want := &model.BinlogInfo{ServerID: 102, File: "mysql-bin.000003", Pos: 260}
+	if err != nil || !reflect.DeepEqual(info, want) {
+		t.Errorf("got %+v %v, want %+v", info, err, want)
+	}
+
+	if _, err = parseBinlogDump(data[:9]); err != ErrMalformPacket {
+		t.Errorf("truncated packet got error %v, want %v", err, ErrMalformPacket)
+	}
+}
+
+func TestParseBinlogDumpGTID(t *testing.T) {
+	sid := []byte{0x3e, 0x11, 0xfa, 0x47, 0x71, 0xca, 0x11, 0xe1, 0x9e, 0x33, 0xc8, 0x0a, 0xa9, 0x42, 0x95, 0x62}
+	cases := []struct {
+		name string
+		data []byte
+		info *model.BinlogInfo
+	}{
+		{"file and pos", binlogDumpGTIDData(103, "mysql-bin.000001", 4, nil),
+			&model.BinlogInfo{ServerID: 103, File: "mysql-bin.000001", Pos: 4}},
+		{"gtid set", binlogDumpGTIDData(104, "", 4, gtidSetData(sid, [2]uint64{1, 6}, [2]uint64{7, 8})),
+			&model.BinlogInfo{ServerID: 104, Pos: 4, GTIDSet: "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5:7"}},
+	}
+
+	for _, c := range cases {
+		info, err := parseBinlogDumpGTID(c.data)
+		if err != nil || !reflect.DeepEqual(info, c.info) {
+			t.Errorf("%s: got %+v %v, want %+v", c.name, info, err, c.info)
+		}
+	}
+
+	data := binlogDumpGTIDData(104, "", 4, gtidSetData(sid, [2]uint64{1, 6}))
+	if _, err := parseBinlogDumpGTID(data[:len(data)-4]); err != ErrMalformPacket {
+		t.Errorf("truncated packet got error %v, want %v", err, ErrMalformPacket)
+	}
+}
+
+func TestBinlogStream(t *testing.T) {
+	ts := newTestSession()
+	ts.client(ComRegisterSlave, 0x65, 0, 0, 0, 5, 'r', 'e', 'p', 'l', '1', 0, 0, 0xea, 0x0c, 0, 0, 0, 0, 0, 0, 0, 0)
+	ts.server([]byte{OKHeader, 0, 0, 0x02, 0, 0, 0})
+	register := ts.piece()
+	if register == nil || *register.QuerySQL != "register slave" || register.Binlog == nil ||
+		register.Binlog.ReportHost != "repl1" {
+		t.Fatalf("register slave should be sent with report host, got %+v", register)
+	}
+
+	dump := append([]byte{ComBinlogDump, 0x04, 0x01, 0, 0, 0, 0, 0x65, 0, 0, 0}, "mysql-bin.000003"...)
+	ts.client(dump...)
+	// the first binlog event finish the dump response
+	event := []byte{OKHeader, 0, 0, 0, 0, 4, 0x65, 0, 0, 0, 0x2c, 0, 0, 0}
+	ts.server(event)
+	piece := ts.piece()
+	if piece == nil || *piece.QuerySQL != "binlog dump mysql-bin.000003:260" ||
+		piece.Command != "binlog_dump" || piece.Binlog.ReportHost != "repl1" ||
+		piece.Binlog.ReportPort != 3306 {
+		t.Fatalf("binlog dump should be sent with replica info, got %+v", piece)
+	}
+	if ts.binlogStream == nil {
+		t.Fatalf("binlog stream should begin after dump")
+	}
+
+	// later events are only counted, and semi-sync ack from replica is not command
+	ts.server(event, event)
+	ts.client(0xef, 0, 4, 0, 0, 0, 0, 0, 0, 0)
+	if piece = ts.piece(); piece != nil {
+		t.Errorf("no piece should be sent before report interval, got %+v", piece)
+	}
+
+	ts.Close()
+	report := ts.piece()
+	if report == nil || *report.QuerySQL != "binlog stream" || report.Command != "binlog_dump" ||
+		report.Binlog.StreamBytes != int64(len(mysqlPackets(event, event))) {
+		t.Errorf("binlog stream bytes should be reported on close, got %+v", report)
+	}
+}
@@ -0,0 +1,245 @@
+package mysql
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Charset information.
+const (
+	CharsetUTF8    = "utf8"
+	CharsetUTF8MB4 = "utf8mb4"
+	CharsetLatin1  = "latin1"
+	CharsetGBK     = "gbk"
+	CharsetGB2312  = "gb2312"
+	CharsetGB18030 = "gb18030"
+	CharsetASCII   = "ascii"
+	CharsetBinary  = "binary"
+)
+
+// collationCharsets map collation id to the charsets need to transcode,
+// the collations not in it are treated as utf8
+var collationCharsets = map[uint16]string{
+	5:   CharsetLatin1,
+	8:   CharsetLatin1,
+	11:  CharsetASCII,
+	15:  CharsetLatin1,
+	24:  CharsetGB2312,
+	28:  CharsetGBK,
+	31:  CharsetLatin1,
+	47:  CharsetLatin1,
+	48:  CharsetLatin1,
+	49:  CharsetLatin1,
+	63:  CharsetBinary,
+	65:  CharsetASCII,
+	86:  CharsetGB2312,
+	87:  CharsetGBK,
+	94:  CharsetLatin1,
+	248: CharsetGB18030,
+	249: CharsetGB18030,
+	250: CharsetGB18030,
+}
+
+var (
+	// setCharsetPattern match SET NAMES, SET CHARACTER SET and SET character_set_client
+	setCharsetPattern = regexp.MustCompile(
+		"(?i)^\\s*set\\s+(?:names\\s+|character\\s+set\\s+|charset\\s+|" +
+			"(?:@@session\\.|@@|session\\s+)?character_set_client\\s*=\\s*)['\"`]?([a-z0-9_]+)")
+)
+
+// cp1252HighTable is the code points of 0x80-0x9f in MySQL latin1, which is cp1252 actually,
+// the undefined ones map to the same control characters as MySQL does
+var cp1252HighTable = [32]rune{
+	0x20ac, 0x0081, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+	0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008d, 0x017d, 0x008f,
+	0x0090, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+	0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0x009d, 0x017e, 0x0178,
+}
+
+const (
+	// gb18030SupplementaryBegin is the linear index of GB18030 four byte character U+10000
+	gb18030SupplementaryBegin = 189000
+	gb18030SupplementaryEnd   = gb18030SupplementaryBegin + utf8.MaxRune - 0x10000
+)
+
+// getCollationCharset return charset name of collation
+func getCollationCharset(collation uint16) string {
+	charset, ok := collationCharsets[collation]
+	if !ok {
+		return CharsetUTF8MB4
+	}
+	return charset
+}
+
+// normalizeCharset return the supported charset name, empty if unknown
+func normalizeCharset(charset string) string {
+	charset = strings.ToLower(charset)
+	switch charset {
+	case CharsetUTF8, "utf8mb3", CharsetUTF8MB4:
+		return CharsetUTF8MB4
+	case CharsetLatin1, CharsetGBK, CharsetGB2312, CharsetGB18030, CharsetASCII, CharsetBinary:
+		return charset
+	case "default":
+		return ""
+	}
+
+	// charsets not support transcoding are checked as utf8
+	return CharsetUTF8MB4
+}
+
+// parseSetCharset get the new client charset from sql like SET NAMES gbk
+func parseSetCharset(querySQL []byte) (charset string, ok bool) {
+	// fast check avoid regexp on most sql
+	idx := 0
+	for idx < len(querySQL) && (querySQL[idx] == ' ' || querySQL[idx] == '\t' ||
+		querySQL[idx] == '\n' || querySQL[idx] == '\r') {
+		idx++
+	}
+	if len(querySQL[idx:]) < 4 || querySQL[idx]|0x20 != 's' ||
+		querySQL[idx+1]|0x20 != 'e' || querySQL[idx+2]|0x20 != 't' {
+		return
+	}
+
+	match := setCharsetPattern.FindSubmatch(querySQL)
+	if match == nil {
+		return
+	}
+
+	charset = normalizeCharset(string(match[1]))
+	ok = len(charset) > 0
+	return
+}
+
+// convertToUTF8 transcode text in charset to valid UTF-8. Bytes cannot be decoded are replaced
+// by U+FFFD one by one, the same as invalid UTF-8 bytes in utf8 charset.
+// Valid UTF-8 text is returned without copy
+func convertToUTF8(charset string, text []byte) []byte {
+	switch charset {
+	case CharsetLatin1:
+		if isASCII(text) {
+			return text
+		}
+		return decodeLatin1(text)
+
+	case CharsetGBK, CharsetGB2312:
+		if isASCII(text) {
+			return text
+		}
+		return decodeGBK(text, false)
+
+	case CharsetGB18030:
+		if isASCII(text) {
+			return text
+		}
+		return decodeGBK(text, true)
+
+	default:
+		if utf8.Valid(text) {
+			return text
+		}
+		return replaceInvalidUTF8(text)
+	}
+}
+
+func isASCII(text []byte) bool {
+	for _, b := range text {
+		if b >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeLatin1(text []byte) []byte {
+	var buffer = bytes.NewBuffer(make([]byte, 0, len(text)+len(text)/2))
+	for _, b := range text {
+		switch {
+		case b < utf8.RuneSelf:
+			buffer.WriteByte(b)
+		case b < 0xa0:
+			buffer.WriteRune(cp1252HighTable[b-0x80])
+		default:
+			buffer.WriteRune(rune(b))
+		}
+	}
+	return buffer.Bytes()
+}
+
+// decodeGBK decode GBK double byte characters, and GB18030 four byte characters if fourByte is set
+func decodeGBK(text []byte, fourByte bool) []byte {
+	var buffer = bytes.NewBuffer(make([]byte, 0, len(text)+len(text)/2))
+	for i := 0; i < len(text); i++ {
+		lead := text[i]
+		if lead < utf8.RuneSelf {
+			buffer.WriteByte(lead)
+			continue
+		}
+
+		if lead >= 0x81 && lead <= 0xfe && i+1 < len(text) {
+			trail := text[i+1]
+			if trail >= 0x40 && trail <= 0xfe && trail != 0x7f {
+				idx := int(lead-0x81)*190 + int(trail-0x40)
+				if trail > 0x7f {
+					idx--
+				}
+				if codePoint := gbkDecodeTable[idx]; codePoint > 0 {
+					buffer.WriteRune(rune(codePoint))
+					i++
+					continue
+				}
+			}
+		}
+
+		if fourByte && i+3 < len(text) {
+			if codePoint, ok := decodeGB18030FourByte(text[i : i+4]); ok {
+				buffer.WriteRune(codePoint)
+				i += 3
+				continue
+			}
+		}
+
+		buffer.WriteRune(utf8.RuneError)
+	}
+	return buffer.Bytes()
+}
+
+// decodeGB18030FourByte decode GB18030 four byte character, the ones in BMP are mapped by ranges table,
+// the ones beyond BMP are continuous from U+10000
+func decodeGB18030FourByte(char []byte) (codePoint rune, ok bool) {
+	if char[0] < 0x81 || char[0] > 0xfe || char[1] < 0x30 || char[1] > 0x39 ||
+		char[2] < 0x81 || char[2] > 0xfe || char[3] < 0x30 || char[3] > 0x39 {
+		return
+	}
+
+	idx := (int(char[0]-0x81)*10+int(char[1]-0x30))*1260 + int(char[2]-0x81)*10 + int(char[3]-0x30)
+	if idx >= gb18030SupplementaryBegin && idx <= gb18030SupplementaryEnd {
+		return rune(idx-gb18030SupplementaryBegin) + 0x10000, true
+	}
+	if idx >= gb18030FourByteLimit {
+		return
+	}
+
+	// find the last range begin not after idx
+	pos := sort.Search(len(gb18030FourByteRanges), func(i int) bool {
+		return int(gb18030FourByteRanges[i][0]) > idx
+	}) - 1
+	begin := gb18030FourByteRanges[pos]
+	return rune(int(begin[1]) + idx - int(begin[0])), true
+}
+
+func replaceInvalidUTF8(text []byte) []byte {
+	var buffer = bytes.NewBuffer(make([]byte, 0, len(text)+16))
+	for len(text) > 0 {
+		r, size := utf8.DecodeRune(text)
+		if r == utf8.RuneError && size == 1 {
+			buffer.WriteRune(utf8.RuneError)
+		} else {
+			buffer.Write(text[:size])
+		}
+		text = text[size:]
+	}
+	return buffer.Bytes()
+}
@@ -0,0 +1,91 @@
+package mysql
+
+import (
+	"testing"
+)
+
+func TestConvertToUTF8(t *testing.T) {
+	cases := []struct {
+		charset string
+		text    []byte
+		result  string
+	}{
+		{CharsetUTF8MB4, []byte("select '中文'"), "select '中文'"},
+		{CharsetUTF8MB4, []byte{'a', 0xff, 'b', 0xe4, 0xb8}, "a\ufffdb\ufffd\ufffd"},
+		{CharsetLatin1, []byte("plain"), "plain"},
+		{CharsetLatin1, []byte{'c', 'a', 'f', 0xe9}, "café"},
+		{CharsetLatin1, []byte{0x80, 0x81, 0x9f}, "€\u0081Ÿ"},
+		{CharsetGBK, []byte{0xc4, 0xe3, 0xba, 0xc3}, "你好"},
+		{CharsetGB18030, []byte{'a', 0xd6, 0xd0, 'b'}, "a中b"},
+		// gb18030 four byte characters in and beyond BMP
+		{CharsetGB18030, []byte{0x81, 0x30, 0x81, 0x30}, "\u0080"},
+		{CharsetGB18030, []byte{0x81, 0x30, 0x84, 0x36, 'z'}, "\u00a5z"},
+		{CharsetGB18030, []byte{0x84, 0x31, 0xa4, 0x39}, "\uffff"},
+		{CharsetGB18030, []byte{0x90, 0x30, 0x81, 0x30}, "\U00010000"},
+		{CharsetGB18030, []byte{0x95, 0x32, 0x82, 0x36}, "\U00020000"},
+		{CharsetGB18030, []byte{0xe3, 0x32, 0x9a, 0x35}, "\U0010ffff"},
+		// four byte sequence is not valid in gbk, and unassigned four byte sequence is invalid
+		{CharsetGBK, []byte{0x81, 0x30, 0x81, 0x30}, "\ufffd0\ufffd0"},
+		{CharsetGB18030, []byte{0x84, 0x32, 0x81, 0x30}, "\ufffd2\ufffd0"},
+		// lead byte without valid trail byte is replaced
+		{CharsetGBK, []byte{'x', 0xc4}, "x\ufffd"},
+		{CharsetGBK, []byte{0xc4, 0x20}, "\ufffd "},
+		{CharsetGBK, []byte{0x80, 'y'}, "\ufffdy"},
+		{CharsetGB18030, []byte{0x81, 0x30, 0x81}, "\ufffd0\ufffd"},
+		{CharsetBinary, []byte{0x00, 0xfe}, "\x00\ufffd"},
+	}
+
+	for _, c := range cases {
+		if result := string(convertToUTF8(c.charset, c.text)); result != c.result {
+			t.Errorf("convert % x in %s\n got: %q\nwant: %q", c.text, c.charset, result, c.result)
+		}
+	}
+}
+
+func TestParseSetCharset(t *testing.T) {
+	cases := []struct {
+		sql     string
+		charset string
+		ok      bool
+	}{
+		{"SET NAMES gbk", CharsetGBK, true},
+		{"  set names 'utf8' collate utf8_general_ci", CharsetUTF8MB4, true},
+		{"set character set latin1", CharsetLatin1, true},
+		{"SET CHARSET gb2312", CharsetGB2312, true},
+		{"set character_set_client = gb18030", CharsetGB18030, true},
+		{"set @@session.character_set_client=`latin1`", CharsetLatin1, true},
+		{"set names default", "", false},
+		{"set names big5", CharsetUTF8MB4, true},
+		{"set character_set_results = gbk", "", false},
+		{"select 'set names gbk'", "", false},
+		{"set", "", false},
+	}
+
+	for _, c := range cases {
+		charset, ok := parseSetCharset([]byte(c.sql))
+		if charset != c.charset || ok != c.ok {
+			t.Errorf("parse %q got %q %v, want %q %v", c.sql, charset, ok, c.charset, c.ok)
+		}
+	}
+}
+
+func TestGetCollationCharset(t *testing.T) {
+	cases := []struct {
+		collation uint16
+		charset   string
+	}{
+		{8, CharsetLatin1},
+		{28, CharsetGBK},
+		{33, CharsetUTF8MB4},
+		{45, CharsetUTF8MB4},
+		{63, CharsetBinary},
+		{248, CharsetGB18030},
+		{255, CharsetUTF8MB4},
+	}
+
+	for _, c := range cases {
+		if charset := getCollationCharset(c.collation); charset != c.charset {
+			t.Errorf("collation %d got charset %s, want %s", c.collation, charset, c.charset)
+		}
+	}
+}
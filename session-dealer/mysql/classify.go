@@ -0,0 +1,199 @@
+package mysql
+
+import (
+	"bytes"
+)
+
+// Statement types of query piece.
+const (
+	StmtTypeSelect  = "select"
+	StmtTypeInsert  = "insert"
+	StmtTypeUpdate  = "update"
+	StmtTypeDelete  = "delete"
+	StmtTypeReplace = "replace"
+	// StmtTypeDDL is statement changes schema, like create, alter, drop, truncate and rename
+	StmtTypeDDL = "ddl"
+	// StmtTypeDCL is statement changes privileges, like grant, revoke and create user
+	StmtTypeDCL = "dcl"
+	// StmtTypeTCL is transaction and locking statement, like begin, commit and lock tables
+	StmtTypeTCL   = "tcl"
+	StmtTypeSet   = "set"
+	StmtTypeShow  = "show"
+	StmtTypeCall  = "call"
+	StmtTypeOther = "other"
+)
+
+var stmtTypes = []string{
+	StmtTypeSelect, StmtTypeInsert, StmtTypeUpdate, StmtTypeDelete, StmtTypeReplace, StmtTypeDDL,
+	StmtTypeDCL, StmtTypeTCL, StmtTypeSet, StmtTypeShow, StmtTypeCall, StmtTypeOther,
+}
+
+// readOnlyTrue and readOnlyFalse are shared by query pieces
+var (
+	readOnlyTrue  = true
+	readOnlyFalse = false
+)
+
+// stmtTypeByWord is statement type decided by the first word
+var stmtTypeByWord = map[string]string{
+	"select":    StmtTypeSelect,
+	"table":     StmtTypeSelect,
+	"values":    StmtTypeSelect,
+	"insert":    StmtTypeInsert,
+	"load":      StmtTypeInsert,
+	"update":    StmtTypeUpdate,
+	"delete":    StmtTypeDelete,
+	"replace":   StmtTypeReplace,
+	"create":    StmtTypeDDL,
+	"alter":     StmtTypeDDL,
+	"drop":      StmtTypeDDL,
+	"truncate":  StmtTypeDDL,
+	"rename":    StmtTypeDDL,
+	"grant":     StmtTypeDCL,
+	"revoke":    StmtTypeDCL,
+	"begin":     StmtTypeTCL,
+	"start":     StmtTypeTCL,
+	"commit":    StmtTypeTCL,
+	"rollback":  StmtTypeTCL,
+	"savepoint": StmtTypeTCL,
+	"release":   StmtTypeTCL,
+	"xa":        StmtTypeTCL,
+	"lock":      StmtTypeTCL,
+	"unlock":    StmtTypeTCL,
+	"set":       StmtTypeSet,
+	"show":      StmtTypeShow,
+	"describe":  StmtTypeShow,
+	"desc":      StmtTypeShow,
+	"explain":   StmtTypeShow,
+	"call":      StmtTypeCall,
+}
+
+// classifyStatement get statement type by leading words of sql, and check if it
+// only reads data, like select without locking and show
+func classifyStatement(sql []byte) (stmtType string, readOnly bool) {
+	var tokenizer sqlTokenizer
+	tokenizer.reset(sql)
+
+	var firstBuffer, secondBuffer [16]byte
+	first := lowerWord(firstBuffer[:0], nextWord(&tokenizer))
+	if string(first) == "with" {
+		first = lowerWord(firstBuffer[:0], skipCommonTableExpr(&tokenizer))
+	}
+	stmtType, ok := stmtTypeByWord[string(first)]
+	if !ok {
+		// use, do, handler, kill and commands without sql
+		return StmtTypeOther, string(first) == "use"
+	}
+
+	second := lowerWord(secondBuffer[:0], nextWord(&tokenizer))
+	switch stmtType {
+	case StmtTypeSelect:
+		return stmtType, !bytes.Equal(first, []byte("select")) || !hasWriteClause(&tokenizer, second)
+
+	case StmtTypeInsert:
+		// load index into cache
+		if string(first) == "load" && string(second) != "data" && string(second) != "xml" {
+			return StmtTypeOther, false
+		}
+
+	case StmtTypeDDL:
+		if string(second) == "user" || string(second) == "role" {
+			return StmtTypeDCL, false
+		}
+
+	case StmtTypeTCL:
+		// start slave and start group_replication
+		if string(first) == "start" && string(second) != "transaction" {
+			return StmtTypeOther, false
+		}
+
+	case StmtTypeSet:
+		if string(second) == "session" || string(second) == "global" || string(second) == "local" {
+			second = lowerWord(secondBuffer[:0], nextWord(&tokenizer))
+		}
+		switch string(second) {
+		case "password", "role", "default":
+			return StmtTypeDCL, false
+		case "transaction":
+			return StmtTypeTCL, false
+		}
+
+	case StmtTypeShow:
+		return stmtType, true
+	}
+	return stmtType, false
+}
+
+// nextWord return the next word skipping parentheses, nil if the next token is not a word
+func nextWord(tokenizer *sqlTokenizer) []byte {
+	for {
+		kind, token := tokenizer.next()
+		switch {
+		case kind == tokenWord:
+			return token
+		case kind == tokenPunct && token[0] == '(':
+			continue
+		default:
+			return nil
+		}
+	}
+}
+
+// skipCommonTableExpr skip WITH clause, return the first word of statement after it
+func skipCommonTableExpr(tokenizer *sqlTokenizer) []byte {
+	var wordBuffer [16]byte
+	depth := 0
+	for {
+		kind, token := tokenizer.next()
+		switch {
+		case kind == tokenEOF:
+			return nil
+		case kind == tokenPunct && token[0] == '(':
+			depth++
+		case kind == tokenPunct && token[0] == ')':
+			depth--
+		case kind == tokenWord && depth == 0:
+			switch string(lowerWord(wordBuffer[:0], token)) {
+			case "select", "insert", "update", "delete", "replace", "table", "values":
+				return token
+			}
+		}
+	}
+}
+
+// hasWriteClause check if select writes file or locks rows for update
+func hasWriteClause(tokenizer *sqlTokenizer, prev []byte) bool {
+	for {
+		kind, token := tokenizer.next()
+		if kind == tokenEOF {
+			return false
+		}
+		if kind != tokenWord {
+			prev = nil
+			continue
+		}
+
+		if bytes.EqualFold(prev, []byte("into")) &&
+			(bytes.EqualFold(token, []byte("outfile")) || bytes.EqualFold(token, []byte("dumpfile"))) {
+			return true
+		}
+		if bytes.EqualFold(prev, []byte("for")) && bytes.EqualFold(token, []byte("update")) {
+			return true
+		}
+		prev = token
+	}
+}
+
+// lowerWord copy word into buffer in lower case, word longer than any keyword is ignored
+func lowerWord(buffer []byte, word []byte) []byte {
+	if len(word) > cap(buffer) {
+		return buffer
+	}
+	for _, c := range word {
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		buffer = append(buffer, c)
+	}
+	return buffer
+}
@@ -0,0 +1,54 @@
+package mysql
+
+import (
+	"testing"
+)
+
+func TestClassifyStatement(t *testing.T) {
+	cases := []struct {
+		sql      string
+		stmtType string
+		readOnly bool
+	}{
+		{"SELECT * FROM t WHERE id = 1", StmtTypeSelect, true},
+		{"/* hint */ (select 1) union (select 2)", StmtTypeSelect, true},
+		{"select * from t where id = 1 for update", StmtTypeSelect, false},
+		{"select id into outfile '/tmp/t.csv' from t", StmtTypeSelect, false},
+		{"select 'for update' from t", StmtTypeSelect, true},
+		{"table t", StmtTypeSelect, true},
+		{"with cte as (select 1 from t) select * from cte", StmtTypeSelect, true},
+		{"WITH cte AS (SELECT id FROM t) DELETE FROM t2 WHERE id IN (SELECT id FROM cte)", StmtTypeDelete, false},
+		{"insert into t values (1)", StmtTypeInsert, false},
+		{"load data local infile 'a.csv' into table t", StmtTypeInsert, false},
+		{"load index into cache t", StmtTypeOther, false},
+		{"update t set a = 1", StmtTypeUpdate, false},
+		{"delete from t", StmtTypeDelete, false},
+		{"replace into t values (1)", StmtTypeReplace, false},
+		{"create table t (id int)", StmtTypeDDL, false},
+		{"Truncate table t", StmtTypeDDL, false},
+		{"create user 'u'@'%'", StmtTypeDCL, false},
+		{"drop role r1", StmtTypeDCL, false},
+		{"grant select on db.* to u", StmtTypeDCL, false},
+		{"begin", StmtTypeTCL, false},
+		{"start transaction", StmtTypeTCL, false},
+		{"start slave", StmtTypeOther, false},
+		{"lock tables t read", StmtTypeTCL, false},
+		{"set autocommit = 0", StmtTypeSet, false},
+		{"set password = 'x'", StmtTypeDCL, false},
+		{"set global default role all to u", StmtTypeDCL, false},
+		{"set session transaction isolation level read committed", StmtTypeTCL, false},
+		{"show processlist", StmtTypeShow, true},
+		{"explain select * from t", StmtTypeShow, true},
+		{"call proc(1)", StmtTypeCall, false},
+		{"use db1", StmtTypeOther, true},
+		{"kill 10", StmtTypeOther, false},
+		{"", StmtTypeOther, false},
+	}
+
+	for _, c := range cases {
+		stmtType, readOnly := classifyStatement([]byte(c.sql))
+		if stmtType != c.stmtType || readOnly != c.readOnly {
+			t.Errorf("classify %q got %s %v, want %s %v", c.sql, stmtType, readOnly, c.stmtType, c.readOnly)
+		}
+	}
+}
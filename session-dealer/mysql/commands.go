@@ -0,0 +1,147 @@
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// commandNames is the name of commands output with query piece
+var commandNames = [...]string{
+	ComSleep:            "sleep",
+	ComQuit:             "quit",
+	ComInitDB:           "init_db",
+	ComQuery:            "query",
+	ComFieldList:        "field_list",
+	ComCreateDB:         "create_db",
+	ComDropDB:           "drop_db",
+	ComRefresh:          "refresh",
+	ComShutdown:         "shutdown",
+	ComStatistics:       "statistics",
+	ComProcessInfo:      "process_info",
+	ComConnect:          "connect",
+	ComProcessKill:      "process_kill",
+	ComDebug:            "debug",
+	ComPing:             "ping",
+	ComTime:             "time",
+	ComDelayedInsert:    "delayed_insert",
+	ComChangeUser:       "change_user",
+	ComBinlogDump:       "binlog_dump",
+	ComTableDump:        "table_dump",
+	ComConnectOut:       "connect_out",
+	ComRegisterSlave:    "register_slave",
+	ComStmtPrepare:      "stmt_prepare",
+	ComStmtExecute:      "stmt_execute",
+	ComStmtSendLongData: "stmt_send_long_data",
+	ComStmtClose:        "stmt_close",
+	ComStmtReset:        "stmt_reset",
+	ComSetOption:        "set_option",
+	ComStmtFetch:        "stmt_fetch",
+	ComBinlogDumpGtid:   "binlog_dump_gtid",
+	ComResetConnection:  "reset_connection",
+}
+
+// refreshOptions is the sub command flags of COM_REFRESH
+var refreshOptions = []struct {
+	flag byte
+	name string
+}{
+	{0x01, "privileges"},
+	{0x02, "logs"},
+	{0x04, "tables"},
+	{0x08, "hosts"},
+	{0x10, "status"},
+	{0x20, "threads"},
+	{0x40, "slave"},
+	{0x80, "master"},
+}
+
+// commandName return name of command, empty if command is unknown
+func commandName(command byte) string {
+	if int(command) < len(commandNames) {
+		return commandNames[command]
+	}
+	return ""
+}
+
+// parseIgnoreCommands parse command names split by comma
+func parseIgnoreCommands(names string) (ignored map[byte]bool) {
+	ignored = make(map[byte]bool)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if len(name) < 1 {
+			continue
+		}
+
+		found := false
+		for command, commandName := range commandNames {
+			if commandName == name {
+				ignored[byte(command)] = true
+				found = true
+			}
+		}
+		if !found {
+			panic(fmt.Sprintf("unknown command %s in ignore commands", name))
+		}
+	}
+	return
+}
+
+// composeCommandSQL generate sql of commands with no sql text, arguments of command are decoded into it
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_command_phase_utility.html
+func composeCommandSQL(command byte, args []byte) string {
+	switch command {
+	case ComFieldList:
+		table := args
+		wildcard := []byte(nil)
+		if idx := bytes.IndexByte(args, 0); idx >= 0 {
+			table = args[:idx]
+			wildcard = args[idx+1:]
+		}
+		if len(wildcard) > 0 {
+			return fmt.Sprintf("field list %s like '%s'", table, wildcard)
+		}
+		return fmt.Sprintf("field list %s", table)
+
+	case ComRefresh:
+		if len(args) < 1 {
+			return "refresh"
+		}
+		var options []string
+		for _, option := range refreshOptions {
+			if args[0]&option.flag > 0 {
+				options = append(options, option.name)
+			}
+		}
+		return fmt.Sprintf("refresh %s", strings.Join(options, ","))
+
+	case ComShutdown:
+		if len(args) > 0 {
+			return fmt.Sprintf("shutdown %d", args[0])
+		}
+		return "shutdown"
+
+	case ComProcessInfo:
+		return "show processlist"
+
+	case ComProcessKill:
+		if len(args) >= 4 {
+			return fmt.Sprintf("kill %d", binary.LittleEndian.Uint32(args[:4]))
+		}
+		return "kill"
+
+	case ComSetOption:
+		if len(args) >= 2 {
+			switch binary.LittleEndian.Uint16(args[:2]) {
+			case 0:
+				return "set option multi_statements_on"
+			case 1:
+				return "set option multi_statements_off"
+			}
+		}
+		return "set option"
+	}
+
+	return strings.Replace(commandName(command), "_", " ", -1)
+}
@@ -0,0 +1,77 @@
+package mysql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComposeCommandSQL(t *testing.T) {
+	cases := []struct {
+		command byte
+		args    []byte
+		sql     string
+	}{
+		{ComFieldList, []byte("users\x00"), "field list users"},
+		{ComFieldList, []byte("users\x00na%"), "field list users like 'na%'"},
+		{ComRefresh, []byte{0x05}, "refresh privileges,tables"},
+		{ComRefresh, nil, "refresh"},
+		{ComShutdown, []byte{0}, "shutdown 0"},
+		{ComProcessInfo, nil, "show processlist"},
+		{ComProcessKill, []byte{0x2a, 0x01, 0, 0}, "kill 298"},
+		{ComProcessKill, []byte{0x2a}, "kill"},
+		{ComSetOption, []byte{0, 0}, "set option multi_statements_on"},
+		{ComSetOption, []byte{1, 0}, "set option multi_statements_off"},
+		{ComPing, nil, "ping"},
+		{ComStatistics, nil, "statistics"},
+		{ComDebug, nil, "debug"},
+	}
+
+	for _, c := range cases {
+		if sql := composeCommandSQL(c.command, c.args); sql != c.sql {
+			t.Errorf("command %s with % x got %q, want %q", commandName(c.command), c.args, sql, c.sql)
+		}
+	}
+}
+
+func TestParseIgnoreCommands(t *testing.T) {
+	ignored := parseIgnoreCommands(" Ping, statistics,,stmt_fetch ")
+	want := map[byte]bool{ComPing: true, ComStatistics: true, ComStmtFetch: true}
+	if !reflect.DeepEqual(ignored, want) {
+		t.Errorf("got %v, want %v", ignored, want)
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("unknown command should panic")
+		}
+	}()
+	parseIgnoreCommands("ping,pong")
+}
+
+func TestCommandPiece(t *testing.T) {
+	ts := newTestSession()
+	ts.client(ComProcessKill, 0x2a, 0, 0, 0)
+	ts.server([]byte{OKHeader, 0, 0, 0x02, 0, 0, 0})
+	piece := ts.piece()
+	if piece == nil || *piece.QuerySQL != "kill 42" || piece.Command != "process_kill" {
+		t.Fatalf("kill should be sent with its command, got %+v", piece)
+	}
+
+	defer func(commands map[byte]bool) {
+		ignoredCommands = commands
+	}(ignoredCommands)
+	ignoredCommands = map[byte]bool{ComPing: true}
+
+	ts.client(ComPing)
+	ts.server([]byte{OKHeader, 0, 0, 0x02, 0, 0, 0})
+	if piece = ts.piece(); piece != nil {
+		t.Errorf("ignored ping should not be sent, got %+v", piece)
+	}
+
+	ts.client(ComStatistics)
+	ts.server([]byte("Uptime: 10  Threads: 1"))
+	piece = ts.piece()
+	if piece == nil || *piece.QuerySQL != "statistics" || piece.Command != "statistics" {
+		t.Errorf("statistics should be sent with its command, got %+v", piece)
+	}
+}
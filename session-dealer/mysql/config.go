@@ -4,18 +4,41 @@ import (
 	"flag"
 	"fmt"
 	"github.com/zr-hebo/sniffer-agent/util"
-	"regexp"
-)
-
-var (
-	uselessSQLPattern = regexp.MustCompile(`(?i)^\s*(select @@version_comment limit 1)`)
-	ddlPatern = regexp.MustCompile(`(?i)^\s*(create|alter|drop)`)
+	"strings"
 )
 
 var (
 	strictMode bool
+	recoverPrepare bool
 	adminUser string
 	adminPasswd string
+	adminPasswdFile string
+	adminPasswdEnv string
+	adminLoginFile string
+	adminLoginPath string
+	adminTargetSpec string
+	adminTLSMode string
+	adminTLSCA string
+	adminTLSCert string
+	adminTLSKey string
+	interpolatePrepareParams bool
+	splitMultiStatements bool
+	binlogStatInterval int
+	ignoreCommandNames string
+	ignoredCommands map[byte]bool
+	syncStmtTypeNames string
+	syncRulesFile string
+	extractTableNames bool
+	tableCacheSize int
+	redactMode string
+	redactColumns string
+	filterRulesFile string
+	riskRuleNames string
+	riskBusinessHours string
+	riskLargeTableMB int
+	connAttrKeys string
+	// connAttrWhitelist is the connection attributes copied into query piece, nil means all
+	connAttrWhitelist map[string]bool
 	// MaxMySQLPacketLen is the max packet payload length.
 	MaxMySQLPacketLen int
 	coverRangePool    = NewCoveragePool()
@@ -25,25 +48,110 @@ var (
 
 func init() {
 	flag.BoolVar(&strictMode,"strict_mode", false, "strict mode. Default is false")
+	flag.BoolVar(&recoverPrepare, "recover_prepare", false, "query statement prepared before sniffer start from performance_schema. Default is false")
 	flag.StringVar(&adminUser,"admin_user", "", "admin user name. When set strict mode, must set admin user to query session info")
 	flag.StringVar(&adminPasswd,"admin_passwd", "", "admin user passwd. When use strict mode, must set admin user to query session info")
-	flag.IntVar(&MaxMySQLPacketLen, "max_packet_length", 128 * 1024, "max mysql packet length. Default is 128 * 1024")
+	flag.StringVar(&adminPasswdFile, "admin_passwd_file", "", "file contains admin user passwd, its mode must be 0600")
+	flag.StringVar(&adminPasswdEnv, "admin_passwd_env", "", "environment variable contains admin user passwd")
+	flag.StringVar(&adminLoginFile, "admin_login_file", "", "login file created by mysql_config_editor contains admin user and passwd, its mode must be 0600")
+	flag.StringVar(&adminLoginPath, "admin_login_path", "client", "login path in admin login file. Default is client")
+	flag.StringVar(&adminTargetSpec, "admin_target", "", "address admin user connect to, unix socket path, host or host:port, use [server_ip:]server_port=address for every sniffed server, split by comma. Default is localhost on sniffed port")
+	flag.StringVar(&adminTLSMode, "admin_tls", "false", "tls of admin connection, true, false or skip-verify. Default is false")
+	flag.StringVar(&adminTLSCA, "admin_tls_ca", "", "CA certificate file to verify server in admin connection")
+	flag.StringVar(&adminTLSCert, "admin_tls_cert", "", "client certificate file of admin connection")
+	flag.StringVar(&adminTLSKey, "admin_tls_key", "", "client key file of admin connection")
+	flag.BoolVar(&interpolatePrepareParams, "interpolate_prepare_params", false, "fill params into prepared statement as interpolated sql. Default is false")
+	flag.BoolVar(&splitMultiStatements, "split_multi_statements", false, "split multi statements query into statements output with query. Default is false")
+	flag.IntVar(&binlogStatInterval, "binlog_stat_interval", 60, "interval seconds to report binlog stream bytes of replica and cdc client. Default is 60")
+	flag.StringVar(&ignoreCommandNames, "ignore_commands", "ping,quit,statistics,stmt_send_long_data,stmt_reset,stmt_close", "commands not output, split by comma. Default is ping,quit,statistics,stmt_send_long_data,stmt_reset,stmt_close")
+	flag.StringVar(&syncStmtTypeNames, "sync_stmt_types", "ddl,dcl", "statement types sent synchronously, split by comma, types are select, insert, update, delete, replace, ddl, dcl, tcl, set, show, call and other, ignored if sync_rules_file set. Default is ddl,dcl")
+	flag.StringVar(&syncRulesFile, "sync_rules_file", "", "json file of sync rules decide which query is sent synchronously, rules can be changed at runtime by config sync_rules")
+	flag.BoolVar(&extractTableNames, "extract_tables", false, "extract tables referenced by statement, output with query. Default is false")
+	flag.IntVar(&tableCacheSize, "table_cache_size", 10000, "max statement digests of which extracted tables are cached. Default is 10000")
+	flag.StringVar(&redactMode, "redact_mode", RedactModeNone, "mask literals in sql and params, none, literals or columns. Credentials are always masked. Default is none")
+	flag.StringVar(&redactColumns, "redact_columns", "", "regexp of column names split by comma, literals of these columns are masked in columns redact mode")
+	flag.StringVar(&filterRulesFile, "filter_rules_file", "", "json file of filter rules decide which query is output, rules can be changed at runtime by config filter_rules. Default only drops select @@version_comment of mysql client")
+	flag.StringVar(&riskRuleNames, "risk_rules", "all", "risk rules flag dangerous statements, split by comma, rules are delete_without_where, update_without_where, always_true_where, drop_table, drop_database, truncate_table, alter_large_table, select_into_outfile and grant_all, empty means no detection. Default is all")
+	flag.StringVar(&riskBusinessHours, "risk_business_hours", "09:00-18:00", "time range in local time when alter large table is risky, empty means never. Default is 09:00-18:00")
+	flag.IntVar(&riskLargeTableMB, "risk_large_table_mb", 1024, "table larger than it in MB is large table in alter_large_table rule. Default is 1024")
+	flag.StringVar(&connAttrKeys, "conn_attrs", "program_name", "connection attributes output with query, split by comma, * means all. Default is program_name")
+	flag.IntVar(&MaxMySQLPacketLen, "max_packet_length", 128 * 1024, "max cached mysql packet length, longer statement is truncated. Default is 128 * 1024")
 }
 
 func PrepareEnv()  {
 	localStmtCache = util.NewSliceBufferPool("statement cache", MaxMySQLPacketLen)
+	connAttrWhitelist = parseConnAttrWhitelist(connAttrKeys)
+	ignoredCommands = parseIgnoreCommands(ignoreCommandNames)
+	config, err := newRedactConfig(redactMode, redactColumns)
+	if err != nil {
+		panic(err.Error())
+	}
+	redactSetting.Store(config)
+	registerRedactConfig()
+	filterRules, err := loadFilterRules(filterRulesFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	filterRuleSetting.Store(filterRules)
+	registerFilterRulesConfig()
+	syncRules, err := loadSyncRules(syncRulesFile, syncStmtTypeNames)
+	if err != nil {
+		panic(err.Error())
+	}
+	syncRuleSetting.Store(syncRules)
+	registerSyncRulesConfig()
+	enabledRisks, err = parseRiskRules(riskRuleNames)
+	if err != nil {
+		panic(err.Error())
+	}
+	businessHours, err = parseBusinessHours(riskBusinessHours)
+	if err != nil {
+		panic(err.Error())
+	}
+	if strictMode || recoverPrepare {
+		startAdminQueries()
+	}
+}
+
+func parseConnAttrWhitelist(keys string) (whitelist map[string]bool) {
+	whitelist = make(map[string]bool)
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		if key == "*" {
+			return nil
+		}
+		if len(key) > 0 {
+			whitelist[key] = true
+		}
+	}
+	return
 }
 
 func CheckParams()  {
-	if !strictMode {
+	if !strictMode && !recoverPrepare {
 		return
 	}
 
+	err := loadAdminCredentials()
+	if err != nil {
+		panic(fmt.Sprintf("load admin user credentials failed <-- %s", err.Error()))
+	}
+
+	adminTargets, err = parseAdminTargets(adminTargetSpec)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	adminTLS, err = registerAdminTLS()
+	if err != nil {
+		panic(fmt.Sprintf("set admin tls failed <-- %s", err.Error()))
+	}
+
 	if len(adminUser) < 1 {
-		panic(fmt.Sprintf("In strict mode, admin user name cannot be empty"))
+		panic(fmt.Sprintf("In strict mode or recover prepare mode, admin user name cannot be empty"))
 	}
 
 	if len(adminPasswd) < 1 {
-		panic(fmt.Sprintf("In strict mode, admin passwd cannot be empty"))
+		panic(fmt.Sprintf("In strict mode or recover prepare mode, admin passwd cannot be empty"))
 	}
 }
@@ -1,53 +1,327 @@
 package mysql
 
 import (
-	"fmt"
+	"database/sql"
+	"sync"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
-	du "github.com/zr-hebo/util-db"
+	log "github.com/golang/glog"
 )
 
-func expandLocalMysql(port int) (mysqlHost *du.MysqlDB) {
-	mysqlHost = new(du.MysqlDB)
-	mysqlHost.IP = "localhost"
-	mysqlHost.Port = port
-	mysqlHost.UserName = adminUser
-	mysqlHost.Passwd = adminPasswd
-	mysqlHost.DatabaseType = "mysql"
-	mysqlHost.ConnectTimeout = 1
+const (
+	// adminQueryWorkers is the number of goroutines query sniffed server with admin connection
+	adminQueryWorkers   = 4
+	adminQueryQueueSize = 1024
+)
 
-	return
+var (
+	// adminConns is the long-lived connection pool to sniffed server, keyed by server ip:port
+	adminConns     = make(map[string]*adminConn)
+	adminConnsLock sync.Mutex
+	// adminQueries is the queue of queries run by workers, never block packet processing goroutine
+	adminQueries chan func()
+)
+
+// adminConn is the connection pool to sniffed server, once connection failed,
+// it is not used until ping succeeded after backoff delay
+type adminConn struct {
+	db       *sql.DB
+	healthy  bool
+	failures uint
+	retryAt  time.Time
+}
+
+// sessionInfo is result of session lookup, err is set if query failed
+type sessionInfo struct {
+	user *string
+	db   *string
+	err  error
+}
+
+// prepareRecovery is result of prepared statement lookup, err is set if query failed
+type prepareRecovery struct {
+	querySQL *string
+	err      error
+}
+
+// prepareLookup is the lookup state of prepared statement, result is set if lookup is pending,
+// failed lookup is retried after backoff delay
+type prepareLookup struct {
+	result   chan *prepareRecovery
+	failures uint
+	retryAt  time.Time
 }
 
-func querySessionInfo(snifferPort int, clientHost *string) (user, db *string, err error) {
-	if clientHost == nil {
+// getAdminConn return the connection pool of admin user to sniffed server,
+// ping is done without lock, so connection to one server never block queries to others
+func getAdminConn(serverIP string, port int) (db *sql.DB, err error) {
+	conn, needPing, err := lookupAdminConn(serverIP, port)
+	if err != nil {
 		return
 	}
+	if !needPing {
+		return conn.db, nil
+	}
+
+	pingErr := conn.db.Ping()
+
+	adminConnsLock.Lock()
+	defer adminConnsLock.Unlock()
+	if pingErr != nil {
+		conn.failures++
+		delay := adminRetryDelay(conn.failures)
+		conn.retryAt = time.Now().Add(delay)
+		log.Warningf("connect to mysql %s failed, retry after %s <-- %s",
+			adminServerKey(serverIP, port), delay, pingErr.Error())
+		return nil, pingErr
+	}
+
+	conn.healthy = true
+	conn.failures = 0
+	return conn.db, nil
+}
+
+// lookupAdminConn get the connection pool of server, create it if not exist,
+// needPing is set if connection is not healthy and backoff delay is over
+func lookupAdminConn(serverIP string, port int) (conn *adminConn, needPing bool, err error) {
+	adminConnsLock.Lock()
+	defer adminConnsLock.Unlock()
+
+	key := adminServerKey(serverIP, port)
+	conn = adminConns[key]
+	if conn == nil {
+		db, err := sql.Open("mysql", adminConfig(serverIP, port).FormatDSN())
+		if err != nil {
+			return nil, false, err
+		}
 
-	mysqlServer := expandLocalMysql(snifferPort)
-	querySQL := fmt.Sprintf(
-		"SELECT USER AS user, DB AS db FROM information_schema.processlist WHERE HOST='%s'", *clientHost)
-	// log.Debug(querySQL)
-	queryRow, err := mysqlServer.QueryRow(querySQL)
+		// all admin query workers share the pool
+		db.SetMaxOpenConns(adminQueryWorkers)
+		db.SetMaxIdleConns(adminQueryWorkers)
+		db.SetConnMaxLifetime(30 * time.Minute)
+		conn = &adminConn{db: db}
+		adminConns[key] = conn
+	}
+
+	if conn.healthy {
+		return conn, false, nil
+	}
+	if time.Now().Before(conn.retryAt) {
+		return nil, false, errAdminConnBackoff
+	}
+
+	// other callers back off while this one is pinging
+	conn.retryAt = time.Now().Add(adminRetryDelay(conn.failures + 1))
+	return conn, true, nil
+}
+
+// checkAdminConn mark connection pool failed if query error is caused by connection
+func checkAdminConn(serverIP string, port int, err error) {
+	if !isAdminConnBroken(err) {
+		return
+	}
+
+	adminConnsLock.Lock()
+	defer adminConnsLock.Unlock()
+	if conn := adminConns[adminServerKey(serverIP, port)]; conn != nil && conn.healthy {
+		conn.healthy = false
+		conn.failures = 1
+		conn.retryAt = time.Now().Add(adminRetryDelay(conn.failures))
+	}
+}
+
+// startAdminQueries start workers query sniffed server off the packet processing goroutine
+func startAdminQueries() {
+	adminQueries = make(chan func(), adminQueryQueueSize)
+	for i := 0; i < adminQueryWorkers; i++ {
+		go func() {
+			for query := range adminQueries {
+				query()
+			}
+		}()
+	}
+}
+
+// submitAdminQuery put query into worker queue, return false if queue is full
+func submitAdminQuery(query func()) bool {
+	select {
+	case adminQueries <- query:
+		return true
+	default:
+		return false
+	}
+}
+
+// querySessionInfo query user and db of session from processlist, match row by thread id if known,
+// otherwise by client host which is ip:port
+func querySessionInfo(serverIP string, serverPort int, clientHost string, threadID uint32) (
+	user, db *string, err error) {
+	adminConn, err := getAdminConn(serverIP, serverPort)
 	if err != nil {
 		return
 	}
 
-	if queryRow == nil {
+	var row *sql.Row
+	if threadID > 0 {
+		row = adminConn.QueryRow(
+			"SELECT USER, DB FROM information_schema.processlist WHERE ID = ?", threadID)
+	} else {
+		row = adminConn.QueryRow(
+			"SELECT USER, DB FROM information_schema.processlist WHERE HOST = ?", clientHost)
+	}
+
+	var userVal, dbVal sql.NullString
+	err = row.Scan(&userVal, &dbVal)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	} else if err != nil {
+		checkAdminConn(serverIP, serverPort, err)
+		return
+	}
+
+	if userVal.Valid {
+		user = &userVal.String
+	}
+	if dbVal.Valid {
+		db = &dbVal.String
+	}
+	return
+}
+
+// checkSessionInfo send lookup of session info in strict mode, and apply the result if received,
+// the result is cached for session lifetime, query pieces generated before it have no user
+func (ms *MysqlSession) checkSessionInfo() {
+	if !strictMode || ms.visitUser != nil || ms.sessionInfoQueried {
+		return
+	}
+
+	if ms.sessionInfoResult == nil {
+		result := make(chan *sessionInfo, 1)
+		serverIP, serverPort, clientHost, threadID := *ms.serverIP, ms.serverPort, *ms.connectionID, ms.serverThreadID
+		if submitAdminQuery(func() {
+			user, db, err := querySessionInfo(serverIP, serverPort, clientHost, threadID)
+			result <- &sessionInfo{user: user, db: db, err: err}
+		}) {
+			ms.sessionInfoResult = result
+		}
+		// if lookup queue is full, try again with next query
+		return
+	}
+
+	select {
+	case info := <-ms.sessionInfoResult:
+		ms.sessionInfoResult = nil
+		if info.err == errAdminConnBackoff {
+			return
+		} else if info.err != nil {
+			log.Errorf("query user and db of session %s from mysql failed <-- %s",
+				*ms.connectionID, info.err.Error())
+			return
+		}
+
+		ms.sessionInfoQueried = true
+		if info.user != nil {
+			ms.visitUser = info.user
+			if ms.visitDB == nil {
+				ms.visitDB = info.db
+			}
+		}
+	default:
+	}
+}
+
+// recoverPreparedStatement send lookup of prepared statement not seen by sniffer, and cache the result
+// if received, execution before the result received has no statement text. Statement not found is cached
+// to avoid query server every execution, failed lookup is retried after backoff delay
+func (ms *MysqlSession) recoverPreparedStatement(stmtID int) (stmt *preparedStatement, ok bool) {
+	lookup := ms.prepareRecoveries[stmtID]
+	if lookup == nil {
+		if ms.prepareRecoveries == nil {
+			ms.prepareRecoveries = make(map[int]*prepareLookup)
+		}
+		lookup = &prepareLookup{}
+		ms.prepareRecoveries[stmtID] = lookup
+	}
+
+	if lookup.result == nil {
+		if time.Now().Before(lookup.retryAt) {
+			return
+		}
+
+		result := make(chan *prepareRecovery, 1)
+		serverIP, serverPort, clientHost, threadID := *ms.serverIP, ms.serverPort, *ms.connectionID, ms.serverThreadID
+		if submitAdminQuery(func() {
+			querySQL, err := queryPreparedStatement(serverIP, serverPort, clientHost, threadID, stmtID)
+			result <- &prepareRecovery{querySQL: querySQL, err: err}
+		}) {
+			lookup.result = result
+		}
+		// if lookup queue is full, try again with next execution
+		return
+	}
+
+	select {
+	case recovery := <-lookup.result:
+		lookup.result = nil
+		if recovery.err == errAdminConnBackoff {
+			return
+		} else if recovery.err != nil {
+			lookup.failures++
+			delay := adminRetryDelay(lookup.failures)
+			lookup.retryAt = time.Now().Add(delay)
+			log.Errorf("query prepare statement %d from mysql failed, retry after %s <-- %s",
+				stmtID, delay, recovery.err.Error())
+			return
+		}
+
+		delete(ms.prepareRecoveries, stmtID)
+		stmt = &preparedStatement{sql: PrepareStatement}
+		if recovery.querySQL != nil {
+			stmt.sql = []byte(*recovery.querySQL)
+			stmt.paramCount = countPlaceholders(stmt.sql)
+			log.Infof("recover prepare statement %s, id:%d", *recovery.querySQL, stmtID)
+		}
+		ms.cachedPrepareStmt[stmtID] = stmt
+		ok = true
+	default:
+	}
+	return
+}
+
+// queryPreparedStatement query sql text of prepared statement from performance_schema,
+// it is used to recover statements prepared before sniffer start
+func queryPreparedStatement(serverIP string, serverPort int, clientHost string, threadID uint32, stmtID int) (
+	querySQL *string, err error) {
+	adminConn, err := getAdminConn(serverIP, serverPort)
+	if err != nil {
 		return
 	}
 
-	userVal := queryRow.Record["user"]
-	if userVal != nil {
-		usrStr := userVal.(string)
-		user = &usrStr
+	var row *sql.Row
+	if threadID > 0 {
+		row = adminConn.QueryRow(
+			"SELECT ps.SQL_TEXT FROM performance_schema.prepared_statements_instances ps "+
+				"JOIN performance_schema.threads t ON ps.OWNER_THREAD_ID = t.THREAD_ID "+
+				"WHERE t.PROCESSLIST_ID = ? AND ps.STATEMENT_ID = ?", threadID, stmtID)
+	} else {
+		row = adminConn.QueryRow(
+			"SELECT ps.SQL_TEXT FROM performance_schema.prepared_statements_instances ps "+
+				"JOIN performance_schema.threads t ON ps.OWNER_THREAD_ID = t.THREAD_ID "+
+				"JOIN information_schema.processlist p ON t.PROCESSLIST_ID = p.ID "+
+				"WHERE p.HOST = ? AND ps.STATEMENT_ID = ?", clientHost, stmtID)
 	}
 
-	dbVal := queryRow.Record["db"]
-	if dbVal != nil {
-		dbStr := dbVal.(string)
-		db = &dbStr
+	var sqlText sql.NullString
+	err = row.Scan(&sqlText)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		checkAdminConn(serverIP, serverPort, err)
+		return
 	}
 
+	if sqlText.Valid {
+		querySQL = &sqlText.String
+	}
 	return
-}
\ No newline at end of file
+}
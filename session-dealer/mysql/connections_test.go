@@ -0,0 +1,68 @@
+package mysql
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRecoverPreparedStatement(t *testing.T) {
+	found := "select * from t where id = ?"
+	cases := []struct {
+		name     string
+		recovery *prepareRecovery
+		ok       bool
+		sql      string
+		// retry means lookup is kept and retried after backoff delay
+		retry bool
+	}{
+		{"found", &prepareRecovery{querySQL: &found}, true, found, false},
+		{"not found", &prepareRecovery{}, true, string(PrepareStatement), false},
+		{"query failed", &prepareRecovery{err: errors.New("i/o timeout")}, false, "", true},
+		{"connection backoff", &prepareRecovery{err: errAdminConnBackoff}, false, "", false},
+	}
+
+	for _, c := range cases {
+		ms := &MysqlSession{cachedPrepareStmt: make(map[int]*preparedStatement)}
+		result := make(chan *prepareRecovery, 1)
+		result <- c.recovery
+		ms.prepareRecoveries = map[int]*prepareLookup{1: {result: result}}
+
+		stmt, ok := ms.recoverPreparedStatement(1)
+		if ok != c.ok {
+			t.Errorf("%s: got ok %v, want %v", c.name, ok, c.ok)
+			continue
+		}
+		if ok {
+			if string(stmt.sql) != c.sql || ms.cachedPrepareStmt[1] != stmt || ms.prepareRecoveries[1] != nil {
+				t.Errorf("%s: got sql %q, statement should be cached and lookup finished", c.name, stmt.sql)
+			}
+			continue
+		}
+
+		if _, cached := ms.cachedPrepareStmt[1]; cached {
+			t.Errorf("%s: failed lookup should not be cached", c.name)
+		}
+		lookup := ms.prepareRecoveries[1]
+		if lookup == nil || lookup.result != nil || lookup.retryAt.After(time.Now()) != c.retry {
+			t.Errorf("%s: got lookup %+v, want retry later %v", c.name, lookup, c.retry)
+		}
+	}
+}
+
+func TestRecoveredStatementParams(t *testing.T) {
+	stmt := &preparedStatement{sql: []byte("select ?"), paramCount: 1}
+	// execution without param types is skipped until types are bound
+	if _, err := parseExecuteParams(stmt, executePacket([]byte{0}, nil, 1), 0); err != errNoParamTypes {
+		t.Errorf("got error %v, want %v", err, errNoParamTypes)
+	}
+
+	params, err := parseExecuteParams(stmt, executePacket([]byte{0}, []byte{TypeTiny, 0}, 1), 0)
+	if err != nil || len(params) != 1 || params[0] != int64(1) {
+		t.Errorf("got params %v error %v", params, err)
+	}
+	params, err = parseExecuteParams(stmt, executePacket([]byte{0}, nil, 2), 0)
+	if err != nil || len(params) != 1 || params[0] != int64(2) {
+		t.Errorf("types bound before should be used, got params %v error %v", params, err)
+	}
+}
@@ -41,7 +41,65 @@ const (
 )
 
 const (
-	maxSQLLen = 5*1024*1024
+	// MaxPayloadLen is the max payload length of one mysql packet,
+	// longer payload is split into several packets
+	MaxPayloadLen = 1<<24 - 1
+)
+
+// MySQL type information.
+const (
+	TypeDecimal byte = iota
+	TypeTiny
+	TypeShort
+	TypeLong
+	TypeFloat
+	TypeDouble
+	TypeNull
+	TypeTimestamp
+	TypeLonglong
+	TypeInt24
+	TypeDate
+	TypeDuration
+	TypeDatetime
+	TypeYear
+	TypeNewDate
+	TypeVarchar
+	TypeBit
+)
+
+// TypeJSON etc. are the types beyond TypeBit.
+const (
+	TypeJSON byte = iota + 0xf5
+	TypeNewDecimal
+	TypeEnum
+	TypeSet
+	TypeTinyBlob
+	TypeMediumBlob
+	TypeLongBlob
+	TypeBlob
+	TypeVarString
+	TypeString
+	TypeGeometry
+)
+
+// Flag information.
+const (
+	// ParamUnsignedFlag is set in the second byte of a parameter type in COM_STMT_EXECUTE.
+	ParamUnsignedFlag byte = 0x80
+
+	// columnFlagUnsigned is the UNSIGNED_FLAG of column definition.
+	columnFlagUnsigned uint16 = 1 << 5
+)
+
+// HandshakeProtocolVersion is the protocol version of initial handshake packet.
+const HandshakeProtocolVersion byte = 10
+
+// Header information.
+const (
+	OKHeader          byte = 0x00
+	ErrHeader         byte = 0xff
+	EOFHeader         byte = 0xfe
+	LocalInFileHeader byte = 0xfb
 )
 
 // Client information.
@@ -68,12 +126,52 @@ const (
 	ClientPluginAuth
 	ClientConnectAtts
 	ClientPluginAuthLenencClientData
+	ClientCanHandleExpiredPasswords
+	ClientSessionTrack
+	ClientDeprecateEOF
+	ClientOptionalResultsetMetadata
+	ClientZstdCompressionAlgorithm
+	ClientQueryAttributes
+)
+
+// Server status information.
+const (
+	ServerStatusInTrans       uint16 = 0x0001
+	ServerStatusAutocommit    uint16 = 0x0002
+	ServerMoreResultsExists   uint16 = 0x0008
+	ServerStatusCursorExists  uint16 = 0x0040
+	ServerStatusLastRowSend   uint16 = 0x0080
+	ServerSessionStateChanged uint16 = 0x4000
 )
 
+// defaultCapability is used when the handshake of session is not captured
+const defaultCapability = ClientProtocol41 | ClientSecureConnection | ClientPluginAuth
+
 
 // Auth name information.
 const (
-	AuthName = "mysql_native_password"
+	AuthName                = "mysql_native_password"
+	AuthCachingSha2Password = "caching_sha2_password"
+	AuthSha256Password      = "sha256_password"
+	AuthClearPassword       = "mysql_clear_password"
+)
+
+// Auth packet header information in connection phase.
+const (
+	AuthSwitchRequest  byte = 0xfe
+	AuthMoreDataHeader byte = 0x01
+)
+
+// Connection phase of session.
+const (
+	// phaseCommand is the default phase, session captured from the middle is in it
+	phaseCommand = iota
+	// phaseHandshake means server greeting is received, wait for client handshake response
+	phaseHandshake
+	// phaseAuth means auth data are exchanging, wait for the final OK or ERR
+	phaseAuth
+	// phaseSSL means connection is encrypted, nothing can be parsed
+	phaseSSL
 )
 
 
@@ -0,0 +1,317 @@
+package mysql
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/zr-hebo/sniffer-agent/communicator"
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+// Actions of filter rule.
+const (
+	FilterActionKeep = "keep"
+	FilterActionDrop = "drop"
+	// FilterActionSample keep matched query piece at probability of sample rate
+	FilterActionSample = "sample"
+)
+
+// FilterRulesConfig is the config name of filter rules which can be set at runtime
+const FilterRulesConfig = "filter_rules"
+
+var (
+	// defaultFilterRules drop the statement mysql client sends after connected
+	defaultFilterRules = `[{"name":"client_version_comment","sql_pattern":"(?i)^\\s*select @@version_comment limit 1","action":"drop"}]`
+	// filterRuleSetting holds current []*FilterRule
+	filterRuleSetting atomic.Value
+)
+
+// pieceCondition is the conditions of rule to match query piece, condition not set matches any piece,
+// user, db, client_cidr, command and stmt_type can be a list split by comma
+type pieceCondition struct {
+	User       string `json:"user,omitempty"`
+	DB         string `json:"db,omitempty"`
+	ClientCIDR string `json:"client_cidr,omitempty"`
+	Command    string `json:"command,omitempty"`
+	StmtType   string `json:"stmt_type,omitempty"`
+	MinCostMS  *int64 `json:"min_cms,omitempty"`
+	MaxCostMS  *int64 `json:"max_cms,omitempty"`
+	// ErrorCode 0 and Error false match statement succeeded, Error true matches any failed statement
+	ErrorCode  *int   `json:"error_code,omitempty"`
+	Error      *bool  `json:"error,omitempty"`
+	SQLPattern string `json:"sql_pattern,omitempty"`
+
+	users      []string
+	dbs        []string
+	clientNets []*net.IPNet
+	commands   []string
+	stmtTypes  []string
+	sqlRegexp  *regexp.Regexp
+}
+
+// compile check and parse conditions
+func (pc *pieceCondition) compile() (err error) {
+	pc.users = splitList(pc.User)
+	pc.dbs = splitList(pc.DB)
+
+	pc.clientNets = nil
+	for _, cidr := range splitList(pc.ClientCIDR) {
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid client_cidr %s <-- %s", cidr, err.Error())
+		}
+		pc.clientNets = append(pc.clientNets, ipNet)
+	}
+
+	pc.commands = nil
+	for _, name := range splitList(pc.Command) {
+		name = strings.ToLower(name)
+		if !isCommandName(name) {
+			return fmt.Errorf("unknown command %s", name)
+		}
+		pc.commands = append(pc.commands, name)
+	}
+
+	pc.stmtTypes = nil
+	for _, name := range splitList(pc.StmtType) {
+		name = strings.ToLower(name)
+		if !isStmtType(name) {
+			return fmt.Errorf("unknown statement type %s", name)
+		}
+		pc.stmtTypes = append(pc.stmtTypes, name)
+	}
+
+	pc.sqlRegexp = nil
+	if len(pc.SQLPattern) > 0 {
+		pc.sqlRegexp, err = regexp.Compile(pc.SQLPattern)
+		if err != nil {
+			return fmt.Errorf("invalid sql_pattern %s <-- %s", pc.SQLPattern, err.Error())
+		}
+	}
+	return
+}
+
+// match check if query piece meets all conditions
+func (pc *pieceCondition) match(mqp *model.PooledMysqlQueryPiece, querySQL []byte) bool {
+	if len(pc.users) > 0 && (mqp.VisitUser == nil || !containsString(pc.users, *mqp.VisitUser)) {
+		return false
+	}
+	if len(pc.dbs) > 0 && (mqp.VisitDB == nil || !containsString(pc.dbs, *mqp.VisitDB)) {
+		return false
+	}
+	if len(pc.commands) > 0 && !containsString(pc.commands, mqp.Command) {
+		return false
+	}
+	if len(pc.stmtTypes) > 0 && !containsString(pc.stmtTypes, mqp.StmtType) {
+		return false
+	}
+	if pc.MinCostMS != nil && mqp.CostTimeInMS < *pc.MinCostMS {
+		return false
+	}
+	if pc.MaxCostMS != nil && mqp.CostTimeInMS > *pc.MaxCostMS {
+		return false
+	}
+	if pc.ErrorCode != nil && pieceErrorCode(mqp) != *pc.ErrorCode {
+		return false
+	}
+	if pc.Error != nil && (pieceErrorCode(mqp) != 0) != *pc.Error {
+		return false
+	}
+	if len(pc.clientNets) > 0 && !pc.matchClient(mqp.ClientHost) {
+		return false
+	}
+	if pc.sqlRegexp != nil && !pc.sqlRegexp.Match(querySQL) {
+		return false
+	}
+	return true
+}
+
+func (pc *pieceCondition) matchClient(clientHost *string) bool {
+	if clientHost == nil {
+		return false
+	}
+	ip := net.ParseIP(*clientHost)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range pc.clientNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterRule decide whether query piece is output, rules are checked in order
+// and the first matched rule takes effect, query piece matches no rule is kept
+type FilterRule struct {
+	// hits is the count of query pieces matched since rule loaded,
+	// it is updated atomically, keep it first for alignment
+	hits int64
+
+	Name string `json:"name,omitempty"`
+	pieceCondition
+	Action     string  `json:"action"`
+	SampleRate float64 `json:"sample_rate,omitempty"`
+}
+
+// filterRuleView is filter rule read at runtime, rule is never changed after loaded,
+// only hits is loaded atomically
+type filterRuleView struct {
+	*FilterRule
+	Hits int64 `json:"hits"`
+}
+
+// parseFilterRules parse rules in json, value is json text or decoded json array
+func parseFilterRules(val interface{}) (rules []*FilterRule, err error) {
+	if err = decodeRules(val, &rules); err != nil {
+		return nil, fmt.Errorf("parse filter rules failed <-- %s", err.Error())
+	}
+
+	for idx, rule := range rules {
+		if rule == nil {
+			return nil, fmt.Errorf("filter rule %d is empty", idx)
+		}
+		rule.Action = strings.ToLower(rule.Action)
+		switch rule.Action {
+		case FilterActionKeep, FilterActionDrop:
+		case FilterActionSample:
+			if rule.SampleRate < 0 || rule.SampleRate > 1 {
+				return nil, fmt.Errorf("sample_rate of filter rule %d must be in [0, 1]", idx)
+			}
+		default:
+			return nil, fmt.Errorf("unknown action %s of filter rule %d", rule.Action, idx)
+		}
+		if err = rule.compile(); err != nil {
+			return nil, fmt.Errorf("filter rule %d <-- %s", idx, err.Error())
+		}
+	}
+	return
+}
+
+// decodeRules decode rules from json text, or from json value decoded already like value of set_config
+func decodeRules(val interface{}, rules interface{}) (err error) {
+	var content []byte
+	switch val := val.(type) {
+	case string:
+		content = []byte(val)
+	case []byte:
+		content = val
+	default:
+		if content, err = json.Marshal(val); err != nil {
+			return fmt.Errorf("cannot transform val: %v to rules", val)
+		}
+	}
+	return json.Unmarshal(content, rules)
+}
+
+// loadFilterRules read rules from file, default rules are used if file not set
+func loadFilterRules(path string) (rules []*FilterRule, err error) {
+	if len(path) < 1 {
+		return parseFilterRules(defaultFilterRules)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read filter rules file %s failed <-- %s", path, err.Error())
+	}
+	return parseFilterRules(content)
+}
+
+func currentFilterRules() []*FilterRule {
+	rules, _ := filterRuleSetting.Load().([]*FilterRule)
+	return rules
+}
+
+// registerFilterRulesConfig make filter rules readable and replaceable at runtime,
+// hits are reset when rules replaced
+func registerFilterRulesConfig() {
+	communicator.RegisterConfig(FilterRulesConfig, func() interface{} {
+		rules := currentFilterRules()
+		views := make([]filterRuleView, 0, len(rules))
+		for _, rule := range rules {
+			views = append(views, filterRuleView{FilterRule: rule, Hits: atomic.LoadInt64(&rule.hits)})
+		}
+		return views
+	}, func(val interface{}) (err error) {
+		rules, err := parseFilterRules(val)
+		if err == nil {
+			filterRuleSetting.Store(rules)
+		}
+		return
+	})
+}
+
+// filterQueryPiece check query piece with filter rules, return false if it should be dropped
+func filterQueryPiece(mqp *model.PooledMysqlQueryPiece, querySQL []byte) (keep bool) {
+	for _, rule := range currentFilterRules() {
+		if !rule.match(mqp, querySQL) {
+			continue
+		}
+
+		atomic.AddInt64(&rule.hits, 1)
+		switch rule.Action {
+		case FilterActionDrop:
+			return false
+		case FilterActionSample:
+			return rand.Float64() < rule.SampleRate
+		}
+		return true
+	}
+	return true
+}
+
+// pieceErrorCode return error code of the last failed result, 0 if statement succeeded
+func pieceErrorCode(mqp *model.PooledMysqlQueryPiece) (errCode int) {
+	for _, result := range mqp.Results {
+		if result.ErrorCode != nil {
+			errCode = *result.ErrorCode
+		}
+	}
+	return
+}
+
+func isCommandName(name string) bool {
+	for _, commandName := range commandNames {
+		if len(commandName) > 0 && commandName == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isStmtType(name string) bool {
+	return containsString(stmtTypes, name)
+}
+
+// splitList split comma separated list, blanks around items are removed
+func splitList(list string) (items []string) {
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return
+}
+
+func containsString(items []string, target string) bool {
+	for _, item := range items {
+		if item == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package mysql
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/zr-hebo/sniffer-agent/communicator"
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+func TestParseFilterRules(t *testing.T) {
+	cases := []struct {
+		rules string
+		err   string
+	}{
+		{`[{"name":"a","action":"DROP"},{"action":"sample","sample_rate":0.5}]`, ""},
+		{`[null]`, "filter rule 0 is empty"},
+		{`[{"action":"pass"}]`, "unknown action pass"},
+		{`[{"action":"sample","sample_rate":1.5}]`, "sample_rate of filter rule 0 must be in [0, 1]"},
+		{`[{"action":"keep","client_cidr":"10.0.0.256"}]`, "invalid client_cidr"},
+		{`[{"action":"keep","command":"query,select"}]`, "unknown command select"},
+		{`[{"action":"keep","stmt_type":"read"}]`, "unknown statement type read"},
+		{`[{"action":"keep","sql_pattern":"(select"}]`, "invalid sql_pattern"},
+		{`{"action":"keep"}`, "parse filter rules failed"},
+	}
+
+	for _, c := range cases {
+		rules, err := parseFilterRules(c.rules)
+		switch {
+		case len(c.err) < 1 && err != nil:
+			t.Errorf("parse %s got error %v", c.rules, err)
+		case len(c.err) > 0 && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("parse %s got error %v, want %s", c.rules, err, c.err)
+		case len(c.err) < 1 && rules[0].Action != FilterActionDrop:
+			t.Errorf("parse %s got action %s, want %s", c.rules, rules[0].Action, FilterActionDrop)
+		}
+	}
+}
+
+func TestPieceConditionMatch(t *testing.T) {
+	user, db, clientHost, errCode := "app", "shop", "10.1.2.3", 1062
+	mqp := &model.PooledMysqlQueryPiece{}
+	mqp.VisitUser = &user
+	mqp.VisitDB = &db
+	mqp.ClientHost = &clientHost
+	mqp.Command = "query"
+	mqp.StmtType = StmtTypeInsert
+	mqp.CostTimeInMS = 120
+	mqp.Results = []model.MysqlResult{{ErrorCode: &errCode}}
+
+	cases := []struct {
+		condition string
+		match     bool
+	}{
+		{`{}`, true},
+		{`{"user":"root, app","db":"shop"}`, true},
+		{`{"user":"root"}`, false},
+		{`{"db":"crm"}`, false},
+		{`{"client_cidr":"10.1.0.0/16"}`, true},
+		{`{"client_cidr":"10.1.2.4,192.168.0.0/16"}`, false},
+		{`{"command":"query","stmt_type":"insert,replace"}`, true},
+		{`{"stmt_type":"select"}`, false},
+		{`{"min_cms":100,"max_cms":200}`, true},
+		{`{"min_cms":200}`, false},
+		{`{"max_cms":100}`, false},
+		{`{"error_code":1062}`, true},
+		{`{"error_code":0}`, false},
+		{`{"error":true}`, true},
+		{`{"error":false}`, false},
+		{`{"sql_pattern":"(?i)^insert into orders"}`, true},
+		{`{"sql_pattern":"^update"}`, false},
+	}
+
+	for _, c := range cases {
+		var condition pieceCondition
+		if err := json.Unmarshal([]byte(c.condition), &condition); err != nil {
+			t.Fatalf("decode condition %s failed <-- %s", c.condition, err.Error())
+		}
+		if err := condition.compile(); err != nil {
+			t.Fatalf("compile condition %s failed <-- %s", c.condition, err.Error())
+		}
+		if match := condition.match(mqp, []byte("INSERT INTO orders VALUES (1)")); match != c.match {
+			t.Errorf("condition %s got match %v, want %v", c.condition, match, c.match)
+		}
+	}
+}
+
+func TestFilterRulesConfig(t *testing.T) {
+	ts := newTestSession()
+	defer filterRuleSetting.Store(currentFilterRules())
+
+	err := communicator.SetConfig(FilterRulesConfig, `[
+		{"name":"drop_ping","sql_pattern":"^select 1$","action":"drop"},
+		{"name":"no_show","stmt_type":"show","action":"sample","sample_rate":0},
+		{"name":"keep_error","error":true,"action":"keep"}]`)
+	if err != nil {
+		t.Fatalf("set filter rules failed <-- %s", err.Error())
+	}
+
+	queries := []struct {
+		sql      string
+		response []byte
+		keep     bool
+	}{
+		{"select 1", okPacket(0, ServerStatusAutocommit), false},
+		{"select 1", okPacket(0, ServerStatusAutocommit), false},
+		{"show tables", okPacket(0, ServerStatusAutocommit), false},
+		{"select 2", errPacket(1054), true},
+		{"select 3", okPacket(0, ServerStatusAutocommit), true},
+	}
+	for _, query := range queries {
+		ts.query(query.sql, query.response)
+		if piece := ts.piece(); (piece != nil) != query.keep {
+			t.Errorf("%s got piece %+v, want kept %v", query.sql, piece, query.keep)
+		}
+	}
+
+	// rules are read with hits, compiled conditions are not output
+	content, err := json.Marshal(communicator.GetConfig(FilterRulesConfig))
+	want := `[{"name":"drop_ping","sql_pattern":"^select 1$","action":"drop","hits":2},` +
+		`{"name":"no_show","stmt_type":"show","action":"sample","hits":1},` +
+		`{"name":"keep_error","error":true,"action":"keep","hits":1}]`
+	if err != nil || string(content) != want {
+		t.Errorf("got filter rules %s %v\nwant: %s", content, err, want)
+	}
+
+	// hits are reset when rules replaced
+	if err = communicator.SetConfig(FilterRulesConfig, []interface{}{
+		map[string]interface{}{"name": "drop_ping", "sql_pattern": "^select 1$", "action": "drop", "hits": 2}}); err != nil {
+		t.Fatalf("set filter rules failed <-- %s", err.Error())
+	}
+	content, _ = json.Marshal(communicator.GetConfig(FilterRulesConfig))
+	if want = `[{"name":"drop_ping","sql_pattern":"^select 1$","action":"drop","hits":0}]`; string(content) != want {
+		t.Errorf("got replaced filter rules %s\nwant: %s", content, want)
+	}
+}
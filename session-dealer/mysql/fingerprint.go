@@ -0,0 +1,173 @@
+package mysql
+
+import (
+	"bytes"
+
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+const (
+	fnvOffset64    uint64 = 14695981039346656037
+	fnvPrime64     uint64 = 1099511628211
+	upperHexDigits        = "0123456789ABCDEF"
+)
+
+// fingerprinter normalize sql into fingerprint like pt-query-digest, it is reused
+// in session so that the buffers are allocated once
+type fingerprinter struct {
+	tokenizer sqlTokenizer
+	buffer    []byte
+	prevKind  int
+	prevToken []byte
+	listWord  bool
+	// listBegin is the position of ( begins a value list in buffer, -1 if not in list
+	listBegin int
+	// listsEnd is the end of collapsed value lists just written, -1 if not, following
+	// lists after comma are merged into them
+	listsEnd   int
+	listsComma bool
+}
+
+// fingerprint remove comments, replace literals with ?, collapse IN and VALUES lists into (?+),
+// collapse blanks and lowercase words, the result refers to buffer until next call
+func (f *fingerprinter) fingerprint(sql []byte) []byte {
+	f.tokenizer.reset(sql)
+	f.buffer = f.buffer[:0]
+	f.prevKind = tokenEOF
+	f.prevToken = nil
+	f.listWord = false
+	f.listBegin = -1
+	f.listsEnd = -1
+	f.listsComma = false
+
+	for {
+		kind, token := f.tokenizer.next()
+		switch kind {
+		case tokenEOF:
+			// trailing semicolon is not a part of statement
+			for len(f.buffer) > 0 && f.buffer[len(f.buffer)-1] == ';' {
+				f.buffer = bytes.TrimRight(f.buffer[:len(f.buffer)-1], " ")
+			}
+			return f.buffer
+
+		case tokenString, tokenNumber, tokenPlaceholder:
+			kind = tokenPlaceholder
+			token = placeholderToken
+		}
+		f.write(kind, token)
+	}
+}
+
+var placeholderToken = []byte("?")
+
+// write append token into buffer with normalized blank before it
+func (f *fingerprinter) write(kind int, token []byte) {
+	punct := byte(0)
+	if kind == tokenPunct {
+		punct = token[0]
+	}
+
+	switch {
+	case f.listBegin >= 0:
+		if kind != tokenPlaceholder && punct != ',' && punct != ')' {
+			f.listBegin = -1
+			f.listsEnd = -1
+		}
+	case punct == '(' && f.listWord:
+		f.listBegin = len(f.buffer)
+	case punct == '(' && f.listsComma:
+		// more lists like values (?+), (1, 2)
+		f.listBegin = f.listsEnd
+	}
+	if f.listBegin < 0 && f.listsEnd >= 0 {
+		f.listsComma = punct == ',' && !f.listsComma
+		if !f.listsComma {
+			f.listsEnd = -1
+		}
+	}
+
+	if f.needSpace(kind, punct) {
+		f.buffer = append(f.buffer, ' ')
+	}
+	if kind == tokenWord {
+		for _, c := range token {
+			if c >= 'A' && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			f.buffer = append(f.buffer, c)
+		}
+	} else {
+		f.buffer = append(f.buffer, token...)
+	}
+
+	if punct == ')' && f.listBegin >= 0 {
+		if f.listBegin == f.listsEnd {
+			f.buffer = f.buffer[:f.listsEnd]
+		} else {
+			f.buffer = append(f.buffer[:f.listBegin], "(?+)"...)
+		}
+		f.listBegin = -1
+		f.listsEnd = len(f.buffer)
+		f.listsComma = false
+	}
+
+	f.listWord = kind == tokenWord && (bytes.EqualFold(token, []byte("in")) ||
+		bytes.EqualFold(token, []byte("values")) || bytes.EqualFold(token, []byte("value")))
+	f.prevKind = kind
+	f.prevToken = token
+}
+
+// needSpace decide if blank is needed between previous token and this one
+func (f *fingerprinter) needSpace(kind int, punct byte) bool {
+	if len(f.buffer) < 1 {
+		return false
+	}
+
+	prevPunct := byte(0)
+	if f.prevKind == tokenPunct {
+		prevPunct = f.prevToken[0]
+	}
+	switch {
+	case punct == ',' || punct == ')' || punct == ';' || punct == '.' || punct == '@':
+		return false
+	case prevPunct == '(' || prevPunct == '.' || prevPunct == '@':
+		return false
+	case prevPunct == ',' || prevPunct == ';':
+		return true
+	case punct == '(':
+		// keep function call like count(*) together, IN and VALUES lists are written as in(?+)
+		if f.listWord {
+			return false
+		}
+		return f.tokenizer.spaceBefore || (f.prevKind != tokenWord && f.prevKind != tokenQuotedIdent)
+	}
+	return true
+}
+
+// digest return 64 bits FNV-1a hash of fingerprint in hex
+func digest(fingerprint []byte) string {
+	hash := fnvOffset64
+	for _, c := range fingerprint {
+		hash ^= uint64(c)
+		hash *= fnvPrime64
+	}
+
+	var hexBytes [16]byte
+	for i := len(hexBytes) - 1; i >= 0; i-- {
+		hexBytes[i] = upperHexDigits[hash&0x0f]
+		hash >>= 4
+	}
+	return string(hexBytes[:])
+}
+
+// setFingerprint fill fingerprint and digest of sql into query piece
+func (ms *MysqlSession) setFingerprint(mqp *model.PooledMysqlQueryPiece, querySQL []byte) {
+	if ms.fingerprinter == nil {
+		ms.fingerprinter = &fingerprinter{}
+	}
+
+	normalized := ms.fingerprinter.fingerprint(querySQL)
+	fingerprint := string(normalized)
+	mqp.Fingerprint = &fingerprint
+	mqp.Digest = digest(normalized)
+}
@@ -0,0 +1,61 @@
+package mysql
+
+import (
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	cases := []struct {
+		sql         string
+		fingerprint string
+	}{
+		{"select 1", "select ?"},
+		{"SELECT  *\n\tFROM t WHERE id = 10 ;", "select * from t where id = ?"},
+		{"select * from t where name = 'a' and b = \"x\" and c = -1.5e3", "select * from t where name = ? and b = ? and c = ?"},
+		{"/* app:web */ select a from t -- tail", "select a from t"},
+		{"select * from t where id in (1, 2, 3)", "select * from t where id in(?+)"},
+		{"select * from t where id IN ( ? , ? )", "select * from t where id in(?+)"},
+		{"insert into t (a, b) values (1, 'x'), (2, 'y')", "insert into t (a, b) values(?+)"},
+		{"insert into t values (1)", "insert into t values(?+)"},
+		{"select count(*) from `T` where `A` = 1", "select count(*) from `T` where `A` = ?"},
+		{"select a.b from db.t a", "select a.b from db.t a"},
+		{"update t set a = ? where b = ?", "update t set a = ? where b = ?"},
+		{"select @a, @@version", "select @a, @@version"},
+		{"create user 'u'@'%' identified by 'x'", "create user ?@? identified by ?"},
+		{"grant select on db.* to `u`@`h`", "grant select on db.* to `u`@`h`"},
+		{"select x'0f', b'1', 0x1f", "select ?, ?, ?"},
+		{"", ""},
+	}
+
+	f := &fingerprinter{}
+	for _, c := range cases {
+		if fingerprint := string(f.fingerprint([]byte(c.sql))); fingerprint != c.fingerprint {
+			t.Errorf("fingerprint %q\n got: %q\nwant: %q", c.sql, fingerprint, c.fingerprint)
+		}
+	}
+}
+
+func TestDigest(t *testing.T) {
+	f := &fingerprinter{}
+	cases := []struct {
+		sqlA string
+		sqlB string
+		same bool
+	}{
+		{"select * from t where id = 1", "SELECT * FROM t WHERE id = 2", true},
+		{"select * from t where id in (1, 2)", "select * from t where id in (3)", true},
+		{"select * from t where id = 1", "select * from u where id = 1", false},
+		{"select a from t", "select b from t", false},
+	}
+
+	for _, c := range cases {
+		digestA := digest(f.fingerprint([]byte(c.sqlA)))
+		digestB := digest(f.fingerprint([]byte(c.sqlB)))
+		if len(digestA) != 16 {
+			t.Errorf("digest of %q is %q, want 16 hex digits", c.sqlA, digestA)
+		}
+		if (digestA == digestB) != c.same {
+			t.Errorf("digest of %q is %s, of %q is %s, same should be %v", c.sqlA, digestA, c.sqlB, digestB, c.same)
+		}
+	}
+}
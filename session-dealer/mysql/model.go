@@ -2,8 +2,20 @@ package mysql
 
 type handshakeResponse41 struct {
 	Capability uint32
-	Collation  uint8
+	Collation  uint16
 	User       string
 	DBName     string
 	Auth       []byte
+	AuthPlugin string
+	Attrs      map[string]string
+}
+
+type handshakeV10 struct {
+	ProtocolVersion uint8
+	ServerVersion   string
+	ConnectionID    uint32
+	Capability      uint32
+	Collation       uint8
+	Status          uint16
+	AuthPlugin      string
 }
@@ -0,0 +1,491 @@
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	log "github.com/golang/glog"
+)
+
+// errNoParamTypes means execution does not bind param types and they are not known before,
+// like statement recovered from server, whose former executions are not captured
+var errNoParamTypes = errors.New("no param types for prepared statement")
+
+// preparedStatement is the prepared statement info cached in session
+type preparedStatement struct {
+	sql        []byte
+	paramCount int
+	// paramTypes keep two bytes for each param: field type and unsigned flag,
+	// the same layout as COM_STMT_EXECUTE sends
+	paramTypes []byte
+	// longData keep data sent by COM_STMT_SEND_LONG_DATA for each param,
+	// it will be used by next COM_STMT_EXECUTE
+	longData map[int][]byte
+	// cursorBeginNano is the begin time of the execution opened cursor, rows are read by COM_STMT_FETCH
+	cursorBeginNano int64
+}
+
+// appendLongData add a chunk of COM_STMT_SEND_LONG_DATA to param
+func (ps *preparedStatement) appendLongData(paramID int, chunk []byte) {
+	if paramID >= ps.paramCount {
+		return
+	}
+
+	if ps.longData == nil {
+		ps.longData = make(map[int][]byte, ps.paramCount)
+	}
+
+	// long data is limited by max packet length as statement
+	data := ps.longData[paramID]
+	if len(data)+len(chunk) > MaxMySQLPacketLen {
+		log.Warningf("long data of param %d is longer than %d, ignore the rest", paramID, MaxMySQLPacketLen)
+		chunk = chunk[:MaxMySQLPacketLen-len(data)]
+	}
+	ps.longData[paramID] = append(data, chunk...)
+}
+
+// binaryParam is param value of blob types or sent by long data, which may be not text,
+// it is exported as hex literal X'..' to keep sql and json valid
+type binaryParam []byte
+
+func (bp binaryParam) String() string {
+	return "X'" + hex.EncodeToString(bp) + "'"
+}
+
+// MarshalJSON export binary param as the hex literal
+func (bp binaryParam) MarshalJSON() ([]byte, error) {
+	return json.Marshal(bp.String())
+}
+
+type prepareInfo struct {
+	prepareStmtID int
+	paramCount    int
+	paramTypes    []byte
+}
+
+// parseResponse parse COM_STMT_PREPARE_OK and the param definitions follow it
+// https://dev.mysql.com/doc/internals/en/com-stmt-prepare-response.html
+func (pi *prepareInfo) parseResponse(data []byte) {
+	defer func() {
+		// param definitions are only used as fallback, ignore malform packets
+		_ = recover()
+	}()
+
+	paramIdx := -1
+	eachMysqlPacket(data, func(payload []byte) bool {
+		if paramIdx < 0 {
+			// status(1) stmt_id(4) num_columns(2) num_params(2) reserved(1) warning_count(2)
+			if len(payload) < 9 || payload[0] != 0 {
+				return false
+			}
+
+			pi.prepareStmtID = bytesToInt(payload[1:5])
+			pi.paramCount = int(binary.LittleEndian.Uint16(payload[7:9]))
+			pi.paramTypes = make([]byte, pi.paramCount*2)
+			paramIdx = 0
+			return pi.paramCount > 0
+		}
+
+		if paramIdx >= pi.paramCount {
+			return false
+		}
+
+		fieldType, flags, ok := parseColumnDefinitionType(payload)
+		if !ok {
+			return false
+		}
+		pi.paramTypes[paramIdx*2] = fieldType
+		if flags&columnFlagUnsigned > 0 {
+			pi.paramTypes[paramIdx*2+1] = ParamUnsignedFlag
+		}
+		paramIdx++
+		return true
+	})
+}
+
+// parseColumnDefinitionType get field type and flags from Protocol::ColumnDefinition41
+func parseColumnDefinitionType(payload []byte) (fieldType byte, flags uint16, ok bool) {
+	offset := 0
+	// catalog, schema, table, org_table, name, org_name
+	for i := 0; i < 6; i++ {
+		num, isNull, n := parseLengthEncodedInt(payload[offset:])
+		offset += n
+		if !isNull {
+			offset += int(num)
+		}
+		if offset >= len(payload) {
+			return
+		}
+	}
+
+	// length of fixed length fields
+	_, _, n := parseLengthEncodedInt(payload[offset:])
+	offset += n
+	// character_set(2) column_length(4) type(1) flags(2)
+	if len(payload) < offset+9 {
+		return
+	}
+
+	fieldType = payload[offset+6]
+	flags = binary.LittleEndian.Uint16(payload[offset+7 : offset+9])
+	ok = true
+	return
+}
+
+// parseExecuteParams decode the parameter values in COM_STMT_EXECUTE with binary protocol,
+// data is the packet without the command byte, capability is the client capability of session,
+// with CLIENT_QUERY_ATTRIBUTES parameter count and names are sent and query attributes follow params
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_stmt_execute.html
+func parseExecuteParams(stmt *preparedStatement, data []byte, capability uint32) (params []interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			params = nil
+			err = ErrMalformPacket
+		}
+	}()
+
+	if stmt.paramCount < 1 {
+		return
+	}
+
+	// stmt_id(4) flags(1) iteration_count(4)
+	offset := 9
+	withAttrs := capability&ClientQueryAttributes > 0
+	paramCount := stmt.paramCount
+	if withAttrs {
+		// parameter count is always sent if statement has params, it counts query attributes too
+		num, _, n := parseLengthEncodedInt(data[offset:])
+		offset += n
+		paramCount = int(num)
+		if paramCount < stmt.paramCount {
+			err = ErrMalformPacket
+			return
+		}
+	}
+
+	nullBitmap := data[offset : offset+(paramCount+7)>>3]
+	offset += len(nullBitmap)
+
+	newParamsBound := data[offset]
+	offset++
+	if newParamsBound == 1 {
+		paramTypes := make([]byte, stmt.paramCount*2)
+		for i := 0; i < paramCount; i++ {
+			if i < stmt.paramCount {
+				copy(paramTypes[i*2:i*2+2], data[offset:offset+2])
+			}
+			offset += 2
+			if withAttrs {
+				// name of param, only query attributes have names
+				num, _, n := parseLengthEncodedInt(data[offset:])
+				offset += n + int(num)
+			}
+		}
+		stmt.paramTypes = paramTypes
+	}
+
+	if len(stmt.paramTypes) < stmt.paramCount*2 {
+		err = errNoParamTypes
+		return
+	}
+
+	// long data is only used by one execution
+	longData := stmt.longData
+	stmt.longData = nil
+
+	// values of query attributes follow params, they are not params of sql
+	params = make([]interface{}, stmt.paramCount)
+	for i := 0; i < stmt.paramCount; i++ {
+		// value of param sent by long data is not in the packet
+		if data, ok := longData[i]; ok {
+			params[i] = binaryParam(data)
+			continue
+		}
+
+		if nullBitmap[i>>3]&(1<<(uint(i)%8)) > 0 {
+			params[i] = nil
+			continue
+		}
+
+		var n int
+		params[i], n, err = parseBinaryValue(
+			stmt.paramTypes[i*2], stmt.paramTypes[i*2+1]&ParamUnsignedFlag > 0, data[offset:])
+		if err != nil {
+			params = nil
+			return
+		}
+		offset += n
+	}
+
+	return
+}
+
+// parseBinaryValue decode one value of binary protocol, return value and the bytes it used
+func parseBinaryValue(fieldType byte, unsigned bool, data []byte) (val interface{}, n int, err error) {
+	switch fieldType {
+	case TypeNull:
+		return nil, 0, nil
+
+	case TypeTiny:
+		if unsigned {
+			return uint64(data[0]), 1, nil
+		}
+		return int64(int8(data[0])), 1, nil
+
+	case TypeShort, TypeYear:
+		v := binary.LittleEndian.Uint16(data[:2])
+		if unsigned {
+			return uint64(v), 2, nil
+		}
+		return int64(int16(v)), 2, nil
+
+	case TypeInt24, TypeLong:
+		v := binary.LittleEndian.Uint32(data[:4])
+		if unsigned {
+			return uint64(v), 4, nil
+		}
+		return int64(int32(v)), 4, nil
+
+	case TypeLonglong:
+		v := binary.LittleEndian.Uint64(data[:8])
+		if unsigned {
+			return v, 8, nil
+		}
+		return int64(v), 8, nil
+
+	case TypeFloat:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(data[:4]))), 4, nil
+
+	case TypeDouble:
+		return math.Float64frombits(binary.LittleEndian.Uint64(data[:8])), 8, nil
+
+	case TypeDate, TypeNewDate, TypeDatetime, TypeTimestamp:
+		return parseBinaryDateTime(fieldType, data)
+
+	case TypeDuration:
+		return parseBinaryDuration(data)
+
+	case TypeDecimal, TypeNewDecimal, TypeVarchar, TypeJSON, TypeEnum, TypeSet, TypeVarString, TypeString:
+		num, isNull, off := parseLengthEncodedInt(data)
+		if isNull {
+			return nil, off, nil
+		}
+		return string(data[off : off+int(num)]), off + int(num), nil
+
+	case TypeBit, TypeTinyBlob, TypeMediumBlob, TypeLongBlob, TypeBlob, TypeGeometry:
+		num, isNull, off := parseLengthEncodedInt(data)
+		if isNull {
+			return nil, off, nil
+		}
+		return binaryParam(data[off : off+int(num)]), off + int(num), nil
+	}
+
+	return nil, 0, fmt.Errorf("unknown param type: %d", fieldType)
+}
+
+func parseBinaryDateTime(fieldType byte, data []byte) (val interface{}, n int, err error) {
+	length := int(data[0])
+	n = length + 1
+	var year, month, day, hour, minute, second, microSecond int
+	switch length {
+	case 0:
+	case 4, 7, 11:
+		year = int(binary.LittleEndian.Uint16(data[1:3]))
+		month = int(data[3])
+		day = int(data[4])
+		if length >= 7 {
+			hour = int(data[5])
+			minute = int(data[6])
+			second = int(data[7])
+		}
+		if length == 11 {
+			microSecond = int(binary.LittleEndian.Uint32(data[8:12]))
+		}
+	default:
+		return nil, n, ErrMalformPacket
+	}
+
+	if fieldType == TypeDate || fieldType == TypeNewDate {
+		return fmt.Sprintf("%04d-%02d-%02d", year, month, day), n, nil
+	}
+
+	dt := fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d", year, month, day, hour, minute, second)
+	if microSecond > 0 {
+		dt = fmt.Sprintf("%s.%06d", dt, microSecond)
+	}
+	return dt, n, nil
+}
+
+func parseBinaryDuration(data []byte) (val interface{}, n int, err error) {
+	length := int(data[0])
+	n = length + 1
+	var negative bool
+	var days, hour, minute, second, microSecond int
+	switch length {
+	case 0:
+	case 8, 12:
+		negative = data[1] == 1
+		days = int(binary.LittleEndian.Uint32(data[2:6]))
+		hour = int(data[6])
+		minute = int(data[7])
+		second = int(data[8])
+		if length == 12 {
+			microSecond = int(binary.LittleEndian.Uint32(data[9:13]))
+		}
+	default:
+		return nil, n, ErrMalformPacket
+	}
+
+	sign := ""
+	if negative {
+		sign = "-"
+	}
+	duration := fmt.Sprintf("%s%02d:%02d:%02d", sign, days*24+hour, minute, second)
+	if microSecond > 0 {
+		duration = fmt.Sprintf("%s.%06d", duration, microSecond)
+	}
+	return duration, n, nil
+}
+
+// countPlaceholders count '?' placeholders in prepared sql
+func countPlaceholders(querySQL []byte) (count int) {
+	eachPlaceholder(querySQL, func(plain []byte, isPlaceholder bool) {
+		if isPlaceholder {
+			count++
+		}
+	})
+	return
+}
+
+// interpolateParams replace the placeholders in prepared sql with param values
+func interpolateParams(querySQL []byte, params []interface{}) string {
+	var buffer = bytes.NewBuffer(make([]byte, 0, len(querySQL)+len(params)*8))
+	paramIdx := 0
+	eachPlaceholder(querySQL, func(plain []byte, isPlaceholder bool) {
+		if !isPlaceholder || paramIdx >= len(params) {
+			buffer.Write(plain)
+			return
+		}
+
+		writeSQLLiteral(buffer, params[paramIdx])
+		paramIdx++
+	})
+
+	return buffer.String()
+}
+
+// eachPlaceholder split sql by '?' placeholders out of quotes and comments
+func eachPlaceholder(querySQL []byte, deal func(plain []byte, isPlaceholder bool)) {
+	eachSeparator(querySQL, '?', deal)
+}
+
+// splitStatements split multi statements query by semicolon out of quotes and comments
+func splitStatements(querySQL []byte) (stmts []string) {
+	eachSeparator(querySQL, ';', func(plain []byte, isSeparator bool) {
+		if isSeparator {
+			return
+		}
+		if stmt := bytes.TrimSpace(plain); len(stmt) > 0 {
+			stmts = append(stmts, string(stmt))
+		}
+	})
+	return
+}
+
+// eachSeparator split sql by separator character out of quotes and comments
+func eachSeparator(querySQL []byte, separator byte, deal func(plain []byte, isSeparator bool)) {
+	begin := 0
+	for i := 0; i < len(querySQL); i++ {
+		switch querySQL[i] {
+		case '\'', '"', '`':
+			quote := querySQL[i]
+			for i++; i < len(querySQL) && querySQL[i] != quote; i++ {
+				if querySQL[i] == '\\' && quote != '`' {
+					i++
+				}
+			}
+
+		case '#':
+			i = skipToLineEnd(querySQL, i)
+
+		case '-':
+			if i+2 < len(querySQL) && querySQL[i+1] == '-' &&
+				(querySQL[i+2] == ' ' || querySQL[i+2] == '\t') {
+				i = skipToLineEnd(querySQL, i)
+			}
+
+		case '/':
+			if i+1 < len(querySQL) && querySQL[i+1] == '*' {
+				end := bytes.Index(querySQL[i+2:], []byte("*/"))
+				if end < 0 {
+					i = len(querySQL)
+				} else {
+					i = i + 2 + end + 1
+				}
+			}
+
+		case separator:
+			deal(querySQL[begin:i], false)
+			deal(querySQL[i:i+1], true)
+			begin = i + 1
+		}
+	}
+
+	if begin < len(querySQL) {
+		deal(querySQL[begin:], false)
+	}
+}
+
+func skipToLineEnd(querySQL []byte, pos int) int {
+	end := bytes.IndexByte(querySQL[pos:], '\n')
+	if end < 0 {
+		return len(querySQL)
+	}
+	return pos + end
+}
+
+func writeSQLLiteral(buffer *bytes.Buffer, val interface{}) {
+	switch realVal := val.(type) {
+	case nil:
+		buffer.WriteString("NULL")
+	case int64:
+		buffer.WriteString(strconv.FormatInt(realVal, 10))
+	case uint64:
+		buffer.WriteString(strconv.FormatUint(realVal, 10))
+	case float64:
+		buffer.WriteString(strconv.FormatFloat(realVal, 'g', -1, 64))
+	case binaryParam:
+		buffer.WriteString(realVal.String())
+	case string:
+		buffer.WriteByte('\'')
+		for i := 0; i < len(realVal); i++ {
+			switch realVal[i] {
+			case 0:
+				buffer.WriteString(`\0`)
+			case '\n':
+				buffer.WriteString(`\n`)
+			case '\r':
+				buffer.WriteString(`\r`)
+			case '\\':
+				buffer.WriteString(`\\`)
+			case '\'':
+				buffer.WriteString(`\'`)
+			case '"':
+				buffer.WriteString(`\"`)
+			case '\032':
+				buffer.WriteString(`\Z`)
+			default:
+				buffer.WriteByte(realVal[i])
+			}
+		}
+		buffer.WriteByte('\'')
+	default:
+		buffer.WriteString(fmt.Sprint(realVal))
+	}
+}
@@ -0,0 +1,191 @@
+package mysql
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// executePacket compose COM_STMT_EXECUTE payload without command byte
+func executePacket(nullBitmap []byte, paramTypes []byte, values ...byte) (data []byte) {
+	// stmt_id, flags and iteration_count
+	data = append(data, 1, 0, 0, 0, 0, 1, 0, 0, 0)
+	data = append(data, nullBitmap...)
+	if paramTypes != nil {
+		data = append(data, 1)
+		data = append(data, paramTypes...)
+	} else {
+		data = append(data, 0)
+	}
+	return append(data, values...)
+}
+
+func TestParseExecuteParams(t *testing.T) {
+	cases := []struct {
+		name       string
+		paramCount int
+		capability uint32
+		boundTypes []byte
+		longData   map[int][]byte
+		data       []byte
+		params     []interface{}
+		err        error
+	}{
+		{
+			name:       "integers",
+			paramCount: 4,
+			data: executePacket([]byte{0}, []byte{TypeTiny, 0, TypeShort, 0, TypeLong, ParamUnsignedFlag, TypeLonglong, 0},
+				0xff, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0, 0, 0, 0, 0, 0, 0),
+			params: []interface{}{int64(-1), int64(-2), uint64(0xffffffff), int64(2)},
+		},
+		{
+			name:       "unsigned tiny and double",
+			paramCount: 2,
+			data: executePacket([]byte{0}, []byte{TypeTiny, ParamUnsignedFlag, TypeDouble, 0},
+				0xff, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f),
+			params: []interface{}{uint64(255), 1.5},
+		},
+		{
+			name:       "null and strings",
+			paramCount: 3,
+			data: executePacket([]byte{0x02}, []byte{TypeVarString, 0, TypeLong, 0, TypeBlob, 0},
+				3, 'a', 'b', 'c', 0),
+			params: []interface{}{"abc", nil, binaryParam{}},
+		},
+		{
+			name:       "date time and duration",
+			paramCount: 4,
+			data: executePacket([]byte{0}, []byte{TypeDate, 0, TypeDatetime, 0, TypeDatetime, 0, TypeDuration, 0},
+				4, 0xe8, 0x07, 2, 29,
+				11, 0xe8, 0x07, 2, 29, 13, 5, 9, 0x40, 0xe2, 0x01, 0,
+				0,
+				8, 1, 1, 0, 0, 0, 2, 3, 4),
+			params: []interface{}{"2024-02-29", "2024-02-29 13:05:09.123456", "0000-00-00 00:00:00", "-26:03:04"},
+		},
+		{
+			name:       "types bound by former execution",
+			paramCount: 1,
+			boundTypes: []byte{TypeShort, ParamUnsignedFlag},
+			data:       executePacket([]byte{0}, nil, 0xff, 0xff),
+			params:     []interface{}{uint64(65535)},
+		},
+		{
+			name:       "blob and bit",
+			paramCount: 2,
+			data: executePacket([]byte{0}, []byte{TypeBlob, 0, TypeBit, 0},
+				3, 0x89, 'P', 0, 1, 0x05),
+			params: []interface{}{binaryParam{0x89, 'P', 0}, binaryParam{0x05}},
+		},
+		{
+			name:       "query attributes",
+			paramCount: 1,
+			capability: ClientQueryAttributes,
+			data: []byte{1, 0, 0, 0, 0, 1, 0, 0, 0,
+				// parameter_count with one attribute, null bitmap and new params bound
+				2, 0, 1,
+				// param type without name, attribute type with name
+				TypeLong, 0, 0, TypeVarString, 0, 5, 't', 'r', 'a', 'c', 'e',
+				7, 0, 0, 0, 2, 'i', 'd'},
+			params: []interface{}{int64(7)},
+		},
+		{
+			name:       "query attributes bound by former execution",
+			paramCount: 1,
+			capability: ClientQueryAttributes,
+			boundTypes: []byte{TypeTiny, 0},
+			data:       []byte{1, 0, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 9},
+			params:     []interface{}{int64(9)},
+		},
+		{
+			name:       "long data is not in packet",
+			paramCount: 2,
+			longData:   map[int][]byte{0: []byte("hello")},
+			data:       executePacket([]byte{0}, []byte{TypeBlob, 0, TypeLong, 0}, 7, 0, 0, 0),
+			params:     []interface{}{binaryParam("hello"), int64(7)},
+		},
+		{
+			name:       "no param",
+			paramCount: 0,
+			data:       executePacket(nil, nil),
+		},
+		{
+			name:       "truncated value",
+			paramCount: 1,
+			data:       executePacket([]byte{0}, []byte{TypeLonglong, 0}, 1, 2),
+			err:        ErrMalformPacket,
+		},
+		{
+			name:       "bad datetime length",
+			paramCount: 1,
+			data:       executePacket([]byte{0}, []byte{TypeDatetime, 0}, 5, 0, 0, 0, 0, 0),
+			err:        ErrMalformPacket,
+		},
+	}
+
+	for _, c := range cases {
+		stmt := &preparedStatement{paramCount: c.paramCount, paramTypes: c.boundTypes, longData: c.longData}
+		params, err := parseExecuteParams(stmt, c.data, c.capability)
+		if err != c.err {
+			t.Errorf("%s: got error %v, want %v", c.name, err, c.err)
+			continue
+		}
+		if !reflect.DeepEqual(params, c.params) {
+			t.Errorf("%s: got params %#v, want %#v", c.name, params, c.params)
+		}
+		if stmt.longData != nil {
+			t.Errorf("%s: long data should be used by one execution only", c.name)
+		}
+	}
+}
+
+func TestAppendLongData(t *testing.T) {
+	stmt := &preparedStatement{paramCount: 2}
+	stmt.appendLongData(0, []byte("ab"))
+	stmt.appendLongData(0, []byte("cd"))
+	stmt.appendLongData(1, []byte("x"))
+	// param id out of range is ignored
+	stmt.appendLongData(2, []byte("y"))
+
+	want := map[int][]byte{0: []byte("abcd"), 1: []byte("x")}
+	if !reflect.DeepEqual(stmt.longData, want) {
+		t.Errorf("got long data %v, want %v", stmt.longData, want)
+	}
+
+	// long data is limited by max packet length
+	defer func(maxLen int) {
+		MaxMySQLPacketLen = maxLen
+	}(MaxMySQLPacketLen)
+	MaxMySQLPacketLen = 6
+	stmt.appendLongData(0, []byte("efgh"))
+	stmt.appendLongData(0, []byte("ijkl"))
+	if string(stmt.longData[0]) != "abcdef" {
+		t.Errorf("got long data %q, want it truncated to abcdef", stmt.longData[0])
+	}
+}
+
+func TestBinaryParamJSON(t *testing.T) {
+	content, err := json.Marshal([]interface{}{binaryParam{0xca, 0xfe}, "x"})
+	if err != nil || string(content) != `["X'cafe'","x"]` {
+		t.Errorf("got json %s error %v", content, err)
+	}
+}
+
+func TestInterpolateParams(t *testing.T) {
+	cases := []struct {
+		sql    string
+		params []interface{}
+		result string
+	}{
+		{"select ?", []interface{}{int64(1)}, "select 1"},
+		{"insert into t values (?, ?, ?)", []interface{}{"it's", nil, 1.5}, "insert into t values ('it\\'s', NULL, 1.5)"},
+		{"select '?', ? from t", []interface{}{uint64(2)}, "select '?', 2 from t"},
+		{"select ?, ?", []interface{}{int64(1)}, "select 1, ?"},
+		{"insert into t values (?, ?)", []interface{}{binaryParam{0x00, 0xff}, "\x00\n"}, "insert into t values (X'00ff', '\\0\\n')"},
+	}
+
+	for _, c := range cases {
+		if result := interpolateParams([]byte(c.sql), c.params); result != c.result {
+			t.Errorf("interpolate %q with %v\n got: %s\nwant: %s", c.sql, c.params, result, c.result)
+		}
+	}
+}
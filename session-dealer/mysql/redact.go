@@ -0,0 +1,384 @@
+package mysql
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pingcap/tidb/util/hack"
+	"github.com/zr-hebo/sniffer-agent/communicator"
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+// Redact modes of literals in sql, credentials are always redacted.
+const (
+	RedactModeNone = "none"
+	// RedactModeLiterals mask all literals and params
+	RedactModeLiterals = "literals"
+	// RedactModeColumns mask literals compared with or inserted into columns matching patterns
+	RedactModeColumns = "columns"
+)
+
+// Config names of redaction which can be set at runtime.
+const (
+	RedactModeConfig    = "redact_mode"
+	RedactColumnsConfig = "redact_columns"
+)
+
+const (
+	redactedLiteral = "?"
+	redactedParam   = "***"
+)
+
+var (
+	// credentialColumnPattern is the columns always redacted, like password in CHANGE MASTER
+	credentialColumnPattern = regexp.MustCompile(`(?i)^((master|source)_)?password$`)
+	// redactSetting holds current *redactConfig
+	redactSetting atomic.Value
+)
+
+// redactConfig is the redaction setting, it is replaced as a whole when changed
+type redactConfig struct {
+	mode          string
+	columns       string
+	columnPattern *regexp.Regexp
+}
+
+// newRedactConfig check mode and compile column patterns split by comma
+func newRedactConfig(mode, columns string) (config *redactConfig, err error) {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	switch mode {
+	case RedactModeNone, RedactModeLiterals, RedactModeColumns:
+	default:
+		return nil, fmt.Errorf("unknown redact mode %s", mode)
+	}
+
+	config = &redactConfig{mode: mode, columns: columns}
+	var patterns []string
+	for _, pattern := range strings.Split(columns, ",") {
+		if pattern = strings.TrimSpace(pattern); len(pattern) > 0 {
+			patterns = append(patterns, "(?:"+pattern+")")
+		}
+	}
+	if len(patterns) > 0 {
+		config.columnPattern, err = regexp.Compile("(?i)^(?:" + strings.Join(patterns, "|") + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact column pattern <-- %s", err.Error())
+		}
+	}
+	return
+}
+
+// maskColumn check if literals compared with or inserted into column should be masked
+func (config *redactConfig) maskColumn(column []byte) bool {
+	if len(column) < 1 {
+		return false
+	}
+	return credentialColumnPattern.Match(column) ||
+		(config.mode == RedactModeColumns && config.columnPattern != nil && config.columnPattern.Match(column))
+}
+
+// currentRedactConfig return redact config in use, credentials are still redacted if not set
+func currentRedactConfig() *redactConfig {
+	if config, ok := redactSetting.Load().(*redactConfig); ok {
+		return config
+	}
+	return &redactConfig{mode: RedactModeNone}
+}
+
+// registerRedactConfig make redact mode and columns configurable at runtime
+func registerRedactConfig() {
+	communicator.RegisterConfig(RedactModeConfig, func() interface{} {
+		return currentRedactConfig().mode
+	}, func(val interface{}) (err error) {
+		mode, ok := val.(string)
+		if !ok {
+			return fmt.Errorf("cannot transform val: %v to string", val)
+		}
+		config, err := newRedactConfig(mode, currentRedactConfig().columns)
+		if err == nil {
+			redactSetting.Store(config)
+		}
+		return
+	})
+
+	communicator.RegisterConfig(RedactColumnsConfig, func() interface{} {
+		return currentRedactConfig().columns
+	}, func(val interface{}) (err error) {
+		columns, ok := val.(string)
+		if !ok {
+			return fmt.Errorf("cannot transform val: %v to string", val)
+		}
+		config, err := newRedactConfig(currentRedactConfig().mode, columns)
+		if err == nil {
+			redactSetting.Store(config)
+		}
+		return
+	})
+}
+
+// literalMasker replace literals in sql by redact config, it follows the column each literal
+// is compared with or inserted into, it is reused in session so that buffers are allocated once
+type literalMasker struct {
+	tokenizer sqlTokenizer
+	buffer    []byte
+	// maskParams is whether the param of each placeholder should be masked
+	maskParams []bool
+	// insertColumns is the column list of INSERT, literals in VALUES match them by position
+	insertColumns [][]byte
+}
+
+// Positions of INSERT column list.
+const (
+	insertNone = iota
+	insertAfterInto
+	insertAfterTable
+	insertInColumns
+)
+
+// mask return sql with literals replaced by ?, masked is nil if nothing is replaced
+func (m *literalMasker) mask(sql []byte, config *redactConfig) (masked []byte) {
+	m.tokenizer.reset(sql)
+	m.buffer = m.buffer[:0]
+	m.maskParams = m.maskParams[:0]
+	m.insertColumns = m.insertColumns[:0]
+
+	var wordBuffer [16]byte
+	var column []byte
+	copied := 0
+	words := 0
+	first := ""
+	changed := false
+	depth := 0
+	insertState := insertNone
+	valuesDepth := -1
+	valueIdx := 0
+	betweenAnd := false
+	identified := false
+	credential := false
+	setPassword := false
+	passwordAssigned := false
+	afterDot := false
+	for {
+		afterDot = m.tokenizer.lastKind == tokenPunct && m.tokenizer.lastToken[0] == '.'
+		kind, token := m.tokenizer.next()
+		begin := m.tokenizer.pos - len(token)
+		switch kind {
+		case tokenEOF:
+			if !changed {
+				return nil
+			}
+			return append(m.buffer, sql[copied:]...)
+
+		case tokenWord, tokenQuotedIdent:
+			word := string(lowerWord(wordBuffer[:0], token))
+			if kind == tokenQuotedIdent {
+				word = ""
+			}
+			words++
+			if words == 1 {
+				first = word
+			} else if words == 2 && first == "set" && word == "password" {
+				setPassword = true
+			}
+			if valuesDepth >= 0 && depth <= valuesDepth && word != "values" && word != "value" {
+				valuesDepth = -1
+			}
+
+			switch {
+			case insertState == insertAfterInto:
+				insertState = insertAfterTable
+			case insertState == insertAfterTable && !afterDot && word != "values" && word != "value":
+				// insert into t set a = 1, insert into t select
+				insertState = insertNone
+			case insertState == insertInColumns:
+				m.insertColumns = append(m.insertColumns, unquoteIdent(token))
+			case word == "into" && (first == "insert" || first == "replace"):
+				insertState = insertAfterInto
+			case word == "values" || word == "value":
+				if insertState == insertAfterTable || len(m.insertColumns) > 0 {
+					valuesDepth = depth
+				}
+				insertState = insertNone
+			case word == "identified":
+				identified = true
+			case word == "by" || word == "as" || word == "replace":
+				credential = identified
+			case word == "between":
+				betweenAnd = true
+			case word == "and" && betweenAnd:
+				betweenAnd = false
+			case word == "and" || word == "or" || word == "where" || word == "set" || word == "on":
+				column = nil
+			case word == "like" || word == "in" || word == "not" || word == "is" || word == "regexp" ||
+				word == "rlike" || word == "binary" || word == "escape" || word == "null":
+			case m.peekPunct('('):
+				// function call, literals in it are compared with the column before
+			default:
+				column = token
+				if kind == tokenQuotedIdent {
+					column = unquoteIdent(token)
+				}
+			}
+
+		case tokenPunct:
+			switch token[0] {
+			case '(':
+				depth++
+				if insertState == insertAfterTable {
+					insertState = insertInColumns
+				}
+				if depth == valuesDepth+1 {
+					valueIdx = 0
+				}
+			case ')':
+				depth--
+				if insertState == insertInColumns {
+					insertState = insertNone
+				}
+			case ',':
+				if valuesDepth >= 0 && depth == valuesDepth+1 {
+					valueIdx++
+				}
+			case ';':
+				// next statement
+				words = 0
+				setPassword, passwordAssigned, identified, credential = false, false, false, false
+				column = nil
+				m.insertColumns = m.insertColumns[:0]
+			}
+
+		case tokenOperator:
+			if setPassword && bytes.Equal(token, []byte("=")) {
+				passwordAssigned = true
+			}
+
+		case tokenString, tokenNumber, tokenPlaceholder:
+			literalColumn := column
+			if valuesDepth >= 0 && depth > valuesDepth && valueIdx < len(m.insertColumns) {
+				literalColumn = m.insertColumns[valueIdx]
+			}
+
+			// literal may be before the column compared with, like 'a@b.c' = email
+			needMask := credential || passwordAssigned || config.mode == RedactModeLiterals ||
+				config.maskColumn(literalColumn) || config.maskColumn(m.peekComparedColumn())
+			credential = false
+
+			if kind == tokenPlaceholder {
+				m.maskParams = append(m.maskParams, needMask)
+			} else if needMask {
+				m.buffer = append(m.buffer, sql[copied:begin]...)
+				m.buffer = append(m.buffer, redactedLiteral...)
+				copied = m.tokenizer.pos
+				changed = true
+			}
+		}
+	}
+}
+
+// peekComparedColumn return the column after comparison operator which follows the literal,
+// nil if literal is not followed by comparison with column
+func (m *literalMasker) peekComparedColumn() (column []byte) {
+	saved := m.tokenizer
+	defer func() {
+		m.tokenizer = saved
+	}()
+
+	kind, token := m.tokenizer.next()
+	if kind != tokenOperator || !isComparisonOperator(token) {
+		return nil
+	}
+	for {
+		kind, token = m.tokenizer.next()
+		switch kind {
+		case tokenWord:
+			column = token
+		case tokenQuotedIdent:
+			column = unquoteIdent(token)
+		default:
+			return nil
+		}
+		// qualified column like u.email, or column in function call like lower(email)
+		if !m.peekPunct('.') && !m.peekPunct('(') {
+			return
+		}
+		m.tokenizer.next()
+	}
+}
+
+func (m *literalMasker) peekPunct(punct byte) bool {
+	saved := m.tokenizer
+	kind, token := m.tokenizer.next()
+	m.tokenizer = saved
+	return kind == tokenPunct && token[0] == punct
+}
+
+func isComparisonOperator(token []byte) bool {
+	switch string(token) {
+	case "=", "<=>", "<>", "!=", "<", ">", "<=", ">=":
+		return true
+	default:
+		return false
+	}
+}
+
+// mayContainCredential check if credential may be in sql, to skip tokenizing when literals are not redacted
+func mayContainCredential(sql string) bool {
+	for i := 0; i+8 <= len(sql); i++ {
+		switch sql[i] | 0x20 {
+		case 'p':
+			if strings.EqualFold(sql[i:i+8], "password") {
+				return true
+			}
+		case 'i':
+			if i+10 <= len(sql) && strings.EqualFold(sql[i:i+10], "identified") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// redact mask literals in sql and params of query piece, interpolated sql and split statements
+// are masked as well
+func (ms *MysqlSession) redact(mqp *model.PooledMysqlQueryPiece) {
+	if mqp.QuerySQL == nil {
+		return
+	}
+	config := currentRedactConfig()
+	if config.mode == RedactModeNone && !mayContainCredential(*mqp.QuerySQL) {
+		return
+	}
+	if ms.literalMasker == nil {
+		ms.literalMasker = &literalMasker{}
+	}
+
+	masker := ms.literalMasker
+	if masked := masker.mask(hack.Slice(*mqp.QuerySQL), config); masked != nil {
+		maskedSQL := string(masked)
+		mqp.QuerySQL = &maskedSQL
+		mqp.Redacted = true
+	}
+	for i, needMask := range masker.maskParams {
+		if needMask && i < len(mqp.Params) && mqp.Params[i] != nil {
+			mqp.Params[i] = redactedParam
+			mqp.Redacted = true
+		}
+	}
+
+	if mqp.InterpolatedSQL != nil {
+		if masked := masker.mask(hack.Slice(*mqp.InterpolatedSQL), config); masked != nil {
+			maskedSQL := string(masked)
+			mqp.InterpolatedSQL = &maskedSQL
+			mqp.Redacted = true
+		}
+	}
+	for i, stmt := range mqp.Statements {
+		if masked := masker.mask(hack.Slice(stmt), config); masked != nil {
+			mqp.Statements[i] = string(masked)
+			mqp.Redacted = true
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package mysql
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+func TestLiteralMasker(t *testing.T) {
+	none, _ := newRedactConfig(RedactModeNone, "email")
+	columns, _ := newRedactConfig(RedactModeColumns, "email, phone|mobile")
+	literals, _ := newRedactConfig(RedactModeLiterals, "")
+
+	cases := []struct {
+		config *redactConfig
+		sql    string
+		// masked is empty if nothing is masked
+		masked     string
+		maskParams []bool
+	}{
+		{none, "select * from u where email = 'a@b.c'", "", nil},
+		{columns, "select * from u where email = 'a@b.c' and age > 18", "select * from u where email = ? and age > 18", nil},
+		{columns, "select * from u where 'a@b.c' = email", "select * from u where ? = email", nil},
+		{columns, "select * from u where 'x' = u.`phone` and 'y' = lower(email) and 1 < age",
+			"select * from u where ? = u.`phone` and ? = lower(email) and 1 < age", nil},
+		{columns, "select * from u where lower(email) = lower('A@B.c') and id between 1 and 10",
+			"select * from u where lower(email) = lower(?) and id between 1 and 10", nil},
+		{columns, "select * from u where t.mobile in ('139', '138') and name like 'x%'",
+			"select * from u where t.mobile in (?, ?) and name like 'x%'", nil},
+		{columns, "insert into u (id, `email`, mobile) values (1, 'a@b', '139'), (2, 'c@d', '138')",
+			"insert into u (id, `email`, mobile) values (1, ?, ?), (2, ?, ?)", nil},
+		{columns, "insert into u set email = 'x', id = 5", "insert into u set email = ?, id = 5", nil},
+		{columns, "update u set email = ?, name = ? where id = ?", "", []bool{true, false, false}},
+		{columns, "select 'a' = 'b', email from u", "", nil},
+		{literals, "select * from u where id in (1, 2) and name = 'n'", "select * from u where id in (?, ?) and name = ?", nil},
+		{literals, "update u set a = ? where id = ?", "", []bool{true, true}},
+		{literals, "create user 'u'@'%'", "create user ?@?", nil},
+		{literals, "select @a, @@version", "", nil},
+		{none, "create user 'bob'@'%' identified by 's3cret'", "create user 'bob'@'%' identified by ?", nil},
+		{none, "alter user bob identified with mysql_native_password by 'x' replace 'old'",
+			"alter user bob identified with mysql_native_password by ? replace ?", nil},
+		{none, "set password for 'bob'@'%' = 'newpw'", "set password for 'bob'@'%' = ?", nil},
+		{none, "change master to master_host='h', master_password='pw', master_port=3306",
+			"change master to master_host='h', master_password=?, master_port=3306", nil},
+		{none, "select 'identified by' from t; select 1", "", nil},
+	}
+
+	masker := &literalMasker{}
+	for _, c := range cases {
+		masked := string(masker.mask([]byte(c.sql), c.config))
+		if masked != c.masked {
+			t.Errorf("mask %q in %s mode\n got: %q\nwant: %q", c.sql, c.config.mode, masked, c.masked)
+		}
+		if len(masker.maskParams) > 0 || len(c.maskParams) > 0 {
+			if !reflect.DeepEqual(masker.maskParams, c.maskParams) {
+				t.Errorf("mask %q in %s mode got mask params %v, want %v",
+					c.sql, c.config.mode, masker.maskParams, c.maskParams)
+			}
+		}
+	}
+}
+
+func TestNewRedactConfig(t *testing.T) {
+	cases := []struct {
+		mode    string
+		columns string
+		valid   bool
+	}{
+		{"none", "", true},
+		{" Columns ", "email,id_?card", true},
+		{"literals", "", true},
+		{"all", "", false},
+		{"columns", "(", false},
+	}
+
+	for _, c := range cases {
+		if _, err := newRedactConfig(c.mode, c.columns); (err == nil) != c.valid {
+			t.Errorf("new redact config with mode %q columns %q got error %v, want valid %v",
+				c.mode, c.columns, err, c.valid)
+		}
+	}
+}
+
+func TestRedactQueryPiece(t *testing.T) {
+	config, _ := newRedactConfig(RedactModeColumns, "email")
+	redactSetting.Store(config)
+	defer redactSetting.Store(&redactConfig{mode: RedactModeNone})
+
+	querySQL := "select * from u where email = ? and id = ?"
+	interpolatedSQL := "select * from u where email = 'a@b.c' and id = 1"
+	mqp := &model.PooledMysqlQueryPiece{}
+	mqp.QuerySQL = &querySQL
+	mqp.InterpolatedSQL = &interpolatedSQL
+	mqp.Params = []interface{}{"a@b.c", int64(1)}
+
+	ms := &MysqlSession{}
+	ms.redact(mqp)
+	if *mqp.InterpolatedSQL != "select * from u where email = ? and id = 1" {
+		t.Errorf("got interpolated sql %q", *mqp.InterpolatedSQL)
+	}
+	if !reflect.DeepEqual(mqp.Params, []interface{}{redactedParam, int64(1)}) {
+		t.Errorf("got params %v", mqp.Params)
+	}
+	if *mqp.QuerySQL != querySQL || !mqp.Redacted {
+		t.Errorf("got sql %q redacted %v", *mqp.QuerySQL, mqp.Redacted)
+	}
+}
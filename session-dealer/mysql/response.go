@@ -0,0 +1,343 @@
+package mysql
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+// state of server response
+const (
+	// respStateResultHead wait for the first packet of a result: OK, ERR or column count
+	respStateResultHead = iota
+	// respStateColumns wait for column definitions of result set
+	respStateColumns
+	// respStateFirstRow is after column definitions, the EOF packet may be there
+	respStateFirstRow
+	// respStateRows wait for rows until EOF, ERR or the OK packet with 0xfe header
+	respStateRows
+	// respStateInfile wait for client sending file content after LOCAL INFILE request
+	respStateInfile
+	// respStateDone means the final result is received
+	respStateDone
+)
+
+const (
+	// packetHeadLen is enough to read OK packet with max length encoded integers
+	packetHeadLen = 32
+	// maxOKPacketLen limit the OK packet cached, which may contain session state info
+	maxOKPacketLen = 16 * 1024
+	// eofPacketLen is length of EOF packet payload in protocol 41,
+	// OK packet with 0xfe header is never so short
+	eofPacketLen = 5
+	// maxTrackedResults limit results record in one query piece
+	maxTrackedResults = 64
+)
+
+// responseTracker follow the server response of command, which may be split into
+// tcp packets in any position, and contains several results chained by SERVER_MORE_RESULTS_EXISTS
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_query_response.html
+type responseTracker struct {
+	state      int
+	header     [4]byte
+	headerIdx  int
+	payloadLen int
+	// payloadLeft is bytes of current packet not read
+	payloadLeft int
+	// head is the beginning of current packet payload
+	head      []byte
+	headLimit int
+	// continued means current packet is the rest of a MaxPayloadLen packet
+	continued  bool
+	columnLeft uint64
+	rows       int64
+
+	results      []model.MysqlResult
+	lastReadNano int64
+	// status is the server status flags in the last OK or EOF packet
+	status      uint16
+	statusKnown bool
+	// lastErrorCode is error code of the last ERR packet
+	lastErrorCode int
+	// sessionStates is the session state info in OK packets
+	sessionStates [][]byte
+
+	localInfile bool
+	infileBytes int64
+	// infileStreamLen is tcp payload length send by client in LOCAL INFILE exchange
+	infileStreamLen int64
+}
+
+// isTrackedCommand check if response of command may contain result sets
+func isTrackedCommand(command byte) bool {
+	switch command {
+	case ComQuery, ComStmtExecute, ComStmtFetch:
+		return true
+	default:
+		return false
+	}
+}
+
+func newResponseTracker(command byte) (rt *responseTracker) {
+	rt = &responseTracker{
+		head: make([]byte, 0, packetHeadLen),
+	}
+	// fetch response is rows of opened cursor without column definitions
+	if command == ComStmtFetch {
+		rt.state = respStateRows
+	}
+	return
+}
+
+func (rt *responseTracker) finished() bool {
+	return rt.state == respStateDone
+}
+
+// read deal with server tcp payload in order
+func (rt *responseTracker) read(bytes []byte) {
+	rt.lastReadNano = time.Now().UnixNano()
+	for len(bytes) > 0 && !rt.finished() {
+		if rt.headerIdx < len(rt.header) {
+			n := copy(rt.header[rt.headerIdx:], bytes)
+			rt.headerIdx += n
+			bytes = bytes[n:]
+			if rt.headerIdx < len(rt.header) {
+				return
+			}
+
+			rt.payloadLen = extractMysqlPayloadSize(rt.header[:])
+			rt.payloadLeft = rt.payloadLen
+			rt.head = rt.head[:0]
+			if rt.payloadLen == 0 {
+				rt.finishPacket()
+			}
+			continue
+		}
+
+		n := minInt(len(bytes), rt.payloadLeft)
+		if len(rt.head) == 0 {
+			rt.headLimit = packetHeadLen
+			if rt.mayBeOKPacket(bytes[0]) {
+				rt.headLimit = maxOKPacketLen
+			}
+		}
+		if len(rt.head) < rt.headLimit {
+			rt.head = append(rt.head, bytes[:minInt(n, rt.headLimit-len(rt.head))]...)
+		}
+		rt.payloadLeft -= n
+		bytes = bytes[n:]
+		if rt.payloadLeft == 0 {
+			rt.finishPacket()
+		}
+	}
+}
+
+func (rt *responseTracker) readingInfile() bool {
+	return rt.state == respStateInfile
+}
+
+// readInfile count file content send by client, the content is split into packets
+// and end with an empty packet, then server response OK or ERR
+func (rt *responseTracker) readInfile(bytes []byte) {
+	rt.infileStreamLen += int64(len(bytes))
+	for len(bytes) > 0 && rt.readingInfile() {
+		if rt.headerIdx < len(rt.header) {
+			n := copy(rt.header[rt.headerIdx:], bytes)
+			rt.headerIdx += n
+			bytes = bytes[n:]
+			if rt.headerIdx < len(rt.header) {
+				return
+			}
+
+			rt.payloadLeft = extractMysqlPayloadSize(rt.header[:])
+			if rt.payloadLeft == 0 {
+				rt.headerIdx = 0
+				rt.state = respStateResultHead
+			}
+			continue
+		}
+
+		n := minInt(len(bytes), rt.payloadLeft)
+		rt.infileBytes += int64(n)
+		rt.payloadLeft -= n
+		bytes = bytes[n:]
+		if rt.payloadLeft == 0 {
+			rt.headerIdx = 0
+		}
+	}
+}
+
+// mayBeOKPacket check if current packet may be OK packet by its first byte,
+// the whole OK packet is cached to read session state info
+func (rt *responseTracker) mayBeOKPacket(firstByte byte) bool {
+	if rt.continued {
+		return false
+	}
+
+	switch rt.state {
+	case respStateResultHead:
+		return firstByte == OKHeader
+	case respStateFirstRow, respStateRows:
+		return firstByte == EOFHeader && rt.payloadLen < MaxPayloadLen && rt.payloadLen != eofPacketLen
+	default:
+		return false
+	}
+}
+
+func (rt *responseTracker) finishPacket() {
+	if !rt.continued && len(rt.head) > 0 {
+		rt.readPacket(rt.head)
+	}
+
+	rt.continued = rt.payloadLen == MaxPayloadLen
+	rt.headerIdx = 0
+}
+
+// readPacket deal with the beginning of a complete mysql packet
+func (rt *responseTracker) readPacket(head []byte) {
+	defer func() {
+		// malformed packet, stop tracking the response
+		if r := recover(); r != nil {
+			rt.state = respStateDone
+		}
+	}()
+
+	switch rt.state {
+	case respStateResultHead:
+		switch head[0] {
+		case OKHeader:
+			affectedRows, status := rt.readOKPacket(head)
+			rt.addResult(model.MysqlResult{AffectedRows: &affectedRows}, status)
+
+		case ErrHeader:
+			rt.addError(head)
+
+		case LocalInFileHeader:
+			rt.localInfile = true
+			rt.state = respStateInfile
+
+		default:
+			columnCount, _, _ := parseLengthEncodedInt(head)
+			rt.columnLeft = columnCount
+			rt.rows = 0
+			rt.state = respStateColumns
+		}
+
+	case respStateColumns:
+		rt.columnLeft--
+		if rt.columnLeft < 1 {
+			rt.state = respStateFirstRow
+		}
+
+	case respStateFirstRow:
+		rt.state = respStateRows
+		if head[0] == EOFHeader && len(head) == eofPacketLen {
+			// cursor is opened, rows will be read by COM_STMT_FETCH
+			if status := binary.LittleEndian.Uint16(head[3:5]); status&ServerStatusCursorExists > 0 {
+				rt.finishResultSet(status &^ ServerMoreResultsExists)
+			}
+			return
+		}
+		rt.readRow(head)
+
+	case respStateRows:
+		rt.readRow(head)
+	}
+}
+
+func (rt *responseTracker) readRow(head []byte) {
+	switch {
+	case head[0] == ErrHeader:
+		rt.addError(head)
+
+	case head[0] == EOFHeader && rt.payloadLen < MaxPayloadLen:
+		var status uint16
+		if len(head) == eofPacketLen {
+			status = binary.LittleEndian.Uint16(head[3:5])
+		} else {
+			_, status = rt.readOKPacket(head)
+		}
+		rt.finishResultSet(status)
+
+	default:
+		rt.rows++
+	}
+}
+
+func (rt *responseTracker) finishResultSet(status uint16) {
+	rows := rt.rows
+	rt.addResult(model.MysqlResult{Rows: &rows}, status)
+}
+
+func (rt *responseTracker) addError(head []byte) {
+	var errCode int
+	if len(head) >= 3 {
+		errCode = int(binary.LittleEndian.Uint16(head[1:3]))
+	}
+	rt.lastErrorCode = errCode
+	rt.addResult(model.MysqlResult{ErrorCode: &errCode}, 0)
+}
+
+func (rt *responseTracker) addResult(result model.MysqlResult, status uint16) {
+	if len(rt.results) < maxTrackedResults {
+		rt.results = append(rt.results, result)
+	}
+	if result.ErrorCode == nil {
+		rt.status = status
+		rt.statusKnown = true
+	}
+
+	if status&ServerMoreResultsExists > 0 {
+		rt.state = respStateResultHead
+	} else {
+		rt.state = respStateDone
+	}
+}
+
+// readOKPacket get affected rows and status flags from OK packet, and keep the session state info
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_basic_ok_packet.html
+func (rt *responseTracker) readOKPacket(packet []byte) (affectedRows int64, status uint16) {
+	offset := 1
+	if offset >= len(packet) {
+		return
+	}
+	num, _, n := parseLengthEncodedInt(packet[offset:])
+	affectedRows = int64(num)
+	offset += n
+
+	// skip last insert id
+	if offset >= len(packet) {
+		return
+	}
+	_, _, n = parseLengthEncodedInt(packet[offset:])
+	offset += n
+
+	if offset+2 > len(packet) {
+		return
+	}
+	status = binary.LittleEndian.Uint16(packet[offset : offset+2])
+	// skip status and warnings
+	offset += 4
+
+	// server set the flag only if client has CLIENT_SESSION_TRACK capability,
+	// then info and session state are both length encoded
+	if status&ServerSessionStateChanged > 0 && offset < len(packet) {
+		_, offset = readLengthEncodedString(packet, offset)
+		stateLen, _, n := parseLengthEncodedInt(packet[offset:])
+		offset += n
+		if offset+int(stateLen) <= len(packet) {
+			state := make([]byte, stateLen)
+			copy(state, packet[offset:offset+int(stateLen)])
+			rt.sessionStates = append(rt.sessionStates, state)
+		}
+	}
+	return
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,111 @@
+package mysql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// describeResults format results of tracker like affected=1 rows=2 error=1146
+func describeResults(rt *responseTracker) string {
+	var results []string
+	for _, result := range rt.results {
+		switch {
+		case result.ErrorCode != nil:
+			results = append(results, fmt.Sprintf("error=%d", *result.ErrorCode))
+		case result.Rows != nil:
+			results = append(results, fmt.Sprintf("rows=%d", *result.Rows))
+		case result.AffectedRows != nil:
+			results = append(results, fmt.Sprintf("affected=%d", *result.AffectedRows))
+		}
+	}
+	return strings.Join(results, " ")
+}
+
+func TestResponseTracker(t *testing.T) {
+	var (
+		okPacket       = []byte{OKHeader, 3, 0, 0x02, 0, 0, 0}
+		moreOKPacket   = []byte{OKHeader, 1, 0, 0x0a, 0, 0, 0}
+		errPacket      = append([]byte{ErrHeader, 0x7a, 0x04, '#', '4', '2', 'S', '0', '2'}, "no table"...)
+		columnCount    = []byte{1}
+		columnDef      = []byte{3, 'd', 'e', 'f'}
+		eofPacket      = []byte{EOFHeader, 0, 0, 0x02, 0}
+		moreEOFPacket  = []byte{EOFHeader, 0, 0, 0x0a, 0}
+		cursorEOF      = []byte{EOFHeader, 0, 0, 0x42, 0}
+		rowA           = []byte{1, 'a'}
+		rowB           = []byte{1, 'b'}
+		deprecatedEOF  = []byte{EOFHeader, 0, 0, 0x02, 0, 0, 0}
+		infileRequest  = append([]byte{LocalInFileHeader}, "/tmp/data.csv"...)
+		tenBytesString = append([]byte{10}, "0123456789"...)
+	)
+
+	cases := []struct {
+		name     string
+		command  byte
+		stream   []byte
+		results  string
+		finished bool
+		status   uint16
+	}{
+		{"ok", ComQuery, mysqlPackets(okPacket), "affected=3", true, ServerStatusAutocommit},
+		{"error", ComQuery, mysqlPackets(errPacket), "error=1146", true, 0},
+		{"result set", ComQuery, mysqlPackets(columnCount, columnDef, eofPacket, rowA, rowB, eofPacket),
+			"rows=2", true, ServerStatusAutocommit},
+		{"result set without eof", ComQuery, mysqlPackets(columnCount, columnDef, rowA, rowB, rowA, deprecatedEOF),
+			"rows=3", true, ServerStatusAutocommit},
+		{"row like greeting", ComQuery, mysqlPackets(columnCount, columnDef, eofPacket, tenBytesString, eofPacket),
+			"rows=1", true, ServerStatusAutocommit},
+		{"empty result set", ComQuery, mysqlPackets(columnCount, columnDef, eofPacket, eofPacket),
+			"rows=0", true, ServerStatusAutocommit},
+		{"error in rows", ComQuery, mysqlPackets(columnCount, columnDef, eofPacket, rowA, errPacket),
+			"error=1146", true, 0},
+		{"multi results", ComQuery,
+			mysqlPackets(moreOKPacket, columnCount, columnDef, eofPacket, rowA, moreEOFPacket, okPacket),
+			"affected=1 rows=1 affected=3", true, ServerStatusAutocommit},
+		{"not finished", ComQuery, mysqlPackets(columnCount, columnDef, eofPacket, rowA), "", false, 0},
+		{"cursor opened", ComStmtExecute, mysqlPackets(columnCount, columnDef, cursorEOF), "rows=0", true, 0x42},
+		{"fetch", ComStmtFetch, mysqlPackets(rowA, rowB, eofPacket), "rows=2", true, ServerStatusAutocommit},
+		{"local infile", ComQuery, mysqlPackets(infileRequest), "", false, 0},
+	}
+
+	for _, c := range cases {
+		// the same response read at once and split into every byte
+		for _, segment := range []int{len(c.stream), 1} {
+			rt := newResponseTracker(c.command)
+			for begin := 0; begin < len(c.stream); begin += segment {
+				rt.read(c.stream[begin:minInt(begin+segment, len(c.stream))])
+			}
+
+			if results := describeResults(rt); results != c.results {
+				t.Errorf("%s in segments of %d: got results %q, want %q", c.name, segment, results, c.results)
+			}
+			if rt.finished() != c.finished {
+				t.Errorf("%s in segments of %d: got finished %v, want %v", c.name, segment, rt.finished(), c.finished)
+			}
+			if c.finished && rt.status != c.status {
+				t.Errorf("%s in segments of %d: got status %#x, want %#x", c.name, segment, rt.status, c.status)
+			}
+		}
+	}
+}
+
+func TestResponseTrackerInfile(t *testing.T) {
+	rt := newResponseTracker(ComQuery)
+	rt.read(mysqlPackets(append([]byte{LocalInFileHeader}, "data.csv"...)))
+	if !rt.readingInfile() || !rt.localInfile {
+		t.Fatalf("should wait for file content after LOCAL INFILE request")
+	}
+
+	// file content in two packets and the empty packet ends it
+	content := mysqlPackets([]byte("1,a\n"), []byte("2,b\n"), []byte{})
+	rt.readInfile(content[:5])
+	rt.readInfile(content[5:])
+	if rt.infileBytes != 8 || rt.infileStreamLen != int64(len(content)) {
+		t.Errorf("got infile bytes %d stream %d, want 8 and %d", rt.infileBytes, rt.infileStreamLen, len(content))
+	}
+
+	rt.read(mysqlPackets([]byte{OKHeader, 2, 0, 0x02, 0, 0, 0}))
+	if results := describeResults(rt); !rt.finished() || results != "affected=2" {
+		t.Errorf("got finished %v results %q, want affected=2", rt.finished(), results)
+	}
+}
@@ -0,0 +1,440 @@
+package mysql
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+// IDs of risk rules.
+const (
+	RiskDeleteWithoutWhere = "delete_without_where"
+	RiskUpdateWithoutWhere = "update_without_where"
+	// RiskAlwaysTrueWhere is delete or update with constant true where, like where 1 = 1
+	RiskAlwaysTrueWhere = "always_true_where"
+	RiskDropTable       = "drop_table"
+	RiskDropDatabase    = "drop_database"
+	RiskTruncateTable   = "truncate_table"
+	// RiskAlterLargeTable is alter table larger than risk_large_table_mb in business hours
+	RiskAlterLargeTable   = "alter_large_table"
+	RiskSelectIntoOutfile = "select_into_outfile"
+	RiskGrantAll          = "grant_all"
+)
+
+var riskRuleIDs = []string{
+	RiskDeleteWithoutWhere, RiskUpdateWithoutWhere, RiskAlwaysTrueWhere, RiskDropTable, RiskDropDatabase,
+	RiskTruncateTable, RiskAlterLargeTable, RiskSelectIntoOutfile, RiskGrantAll,
+}
+
+// tableSizeTTL is how long the size of table queried from server is cached
+const tableSizeTTL = 10 * time.Minute
+
+var (
+	// enabledRisks is the risk rules checked, nil means risk detection is disabled
+	enabledRisks map[string]bool
+	// businessHours is the minutes of day when alter large table is risky, begin > end means overnight
+	businessHours *minuteRange
+	tableSizes    = make(map[string]*tableSize)
+	tableSizeLock sync.Mutex
+)
+
+type minuteRange struct {
+	begin int
+	end   int
+}
+
+// tableSize is the data and index size of table, size is -1 if unknown
+type tableSize struct {
+	size      int64
+	queriedAt time.Time
+}
+
+// parseRiskRules parse risk rule IDs split by comma, all means every rule
+func parseRiskRules(names string) (risks map[string]bool, err error) {
+	for _, name := range splitList(names) {
+		name = strings.ToLower(name)
+		if risks == nil {
+			risks = make(map[string]bool)
+		}
+		if name == "all" {
+			for _, id := range riskRuleIDs {
+				risks[id] = true
+			}
+			continue
+		}
+		if !containsString(riskRuleIDs, name) {
+			return nil, fmt.Errorf("unknown risk rule %s", name)
+		}
+		risks[name] = true
+	}
+	return
+}
+
+// parseBusinessHours parse time range like 09:00-18:00, empty range is nil
+func parseBusinessHours(hours string) (mr *minuteRange, err error) {
+	hours = strings.TrimSpace(hours)
+	if len(hours) < 1 {
+		return nil, nil
+	}
+
+	var beginHour, beginMinute, endHour, endMinute int
+	_, err = fmt.Sscanf(hours, "%d:%d-%d:%d", &beginHour, &beginMinute, &endHour, &endMinute)
+	if err != nil || beginHour > 24 || endHour > 24 || beginMinute > 59 || endMinute > 59 ||
+		beginHour < 0 || endHour < 0 || beginMinute < 0 || endMinute < 0 {
+		return nil, fmt.Errorf("invalid business hours %s, should be like 09:00-18:00", hours)
+	}
+	return &minuteRange{begin: beginHour*60 + beginMinute, end: endHour*60 + endMinute}, nil
+}
+
+func (mr *minuteRange) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if mr.begin <= mr.end {
+		return mr.begin <= minute && minute < mr.end
+	}
+	return minute >= mr.begin || minute < mr.end
+}
+
+// riskDetector find risky statements by tokens, it is reused in session
+type riskDetector struct {
+	tokenizer sqlTokenizer
+	risks     []string
+	// alterTables is the tables altered, size of them is checked later
+	alterTables [][2][]byte
+}
+
+// detect return IDs of risks in sql, the result refers to detector until next call
+func (d *riskDetector) detect(sql []byte) []string {
+	d.tokenizer.reset(sql)
+	d.risks = d.risks[:0]
+	d.alterTables = d.alterTables[:0]
+	for d.detectStatement() {
+	}
+	return d.risks
+}
+
+// detectStatement check one statement, return false at the end of sql
+func (d *riskDetector) detectStatement() bool {
+	saved := d.tokenizer
+	if kind, _ := d.tokenizer.next(); kind == tokenEOF {
+		return false
+	}
+	d.tokenizer = saved
+
+	var firstBuffer, secondBuffer [16]byte
+	first := lowerWord(firstBuffer[:0], nextWord(&d.tokenizer))
+	if string(first) == "with" {
+		first = lowerWord(firstBuffer[:0], skipCommonTableExpr(&d.tokenizer))
+	}
+
+	switch string(first) {
+	case "delete", "update":
+		risk := RiskDeleteWithoutWhere
+		if string(first) == "update" {
+			risk = RiskUpdateWithoutWhere
+		}
+		if !d.skipToWhere() {
+			d.addRisk(risk)
+			return d.skipStatement()
+		}
+		if d.whereAlwaysTrue() {
+			d.addRisk(RiskAlwaysTrueWhere)
+		}
+
+	case "drop":
+		second := lowerWord(secondBuffer[:0], nextWord(&d.tokenizer))
+		switch string(second) {
+		case "table", "tables":
+			d.addRisk(RiskDropTable)
+		case "database", "schema":
+			d.addRisk(RiskDropDatabase)
+		}
+
+	case "truncate":
+		d.addRisk(RiskTruncateTable)
+
+	case "alter":
+		second := lowerWord(secondBuffer[:0], nextWord(&d.tokenizer))
+		for string(second) == "online" || string(second) == "ignore" {
+			second = lowerWord(secondBuffer[:0], nextWord(&d.tokenizer))
+		}
+		if string(second) == "table" {
+			d.readAlterTable()
+		}
+
+	case "select":
+		if d.hasIntoOutfile() {
+			d.addRisk(RiskSelectIntoOutfile)
+		}
+
+	case "grant":
+		if bytes.EqualFold(nextWord(&d.tokenizer), []byte("all")) {
+			d.addRisk(RiskGrantAll)
+		}
+	}
+	return d.skipStatement()
+}
+
+func (d *riskDetector) addRisk(risk string) {
+	if enabledRisks[risk] && !containsString(d.risks, risk) {
+		d.risks = append(d.risks, risk)
+	}
+}
+
+// skipStatement move to the next statement, return false at the end of sql
+func (d *riskDetector) skipStatement() bool {
+	if d.tokenizer.lastKind == tokenPunct && d.tokenizer.lastToken[0] == ';' {
+		return true
+	}
+	for {
+		kind, token := d.tokenizer.next()
+		switch {
+		case kind == tokenEOF:
+			return false
+		case kind == tokenPunct && token[0] == ';':
+			return true
+		}
+	}
+}
+
+// skipToWhere move over tokens until WHERE of statement, return false if there is no WHERE
+func (d *riskDetector) skipToWhere() bool {
+	depth := 0
+	for {
+		kind, token := d.tokenizer.next()
+		switch {
+		case kind == tokenEOF:
+			return false
+		case kind == tokenPunct && token[0] == '(':
+			depth++
+		case kind == tokenPunct && token[0] == ')':
+			depth--
+		case kind == tokenPunct && token[0] == ';' && depth == 0:
+			return false
+		case kind == tokenWord && depth == 0 && bytes.EqualFold(token, []byte("where")):
+			return true
+		}
+	}
+}
+
+// whereAlwaysTrue check if where clause is constant true, like 1 = 1 or id = 3 or 1,
+// the clause is split into terms by AND and OR, it is true if all terms of any OR
+// branch are constant true
+func (d *riskDetector) whereAlwaysTrue() (alwaysTrue bool) {
+	var term [][]byte
+	termKinds := make([]int, 0, 4)
+	branchTrue := true
+	depth := 0
+	endTerm := func() {
+		branchTrue = branchTrue && constantTrue(termKinds, term)
+		term = term[:0]
+		termKinds = termKinds[:0]
+	}
+
+	for {
+		saved := d.tokenizer
+		kind, token := d.tokenizer.next()
+		if kind == tokenPunct && token[0] == '(' {
+			depth++
+			continue
+		} else if kind == tokenPunct && token[0] == ')' {
+			depth--
+			continue
+		}
+
+		clauseEnd := kind == tokenEOF || depth < 0 || (depth == 0 && kind == tokenPunct && token[0] == ';') ||
+			(depth == 0 && kind == tokenWord && (bytes.EqualFold(token, []byte("order")) ||
+				bytes.EqualFold(token, []byte("limit"))))
+		isOr := depth == 0 && ((kind == tokenWord && bytes.EqualFold(token, []byte("or"))) ||
+			(kind == tokenOperator && string(token) == "||"))
+		isAnd := depth == 0 && ((kind == tokenWord && bytes.EqualFold(token, []byte("and"))) ||
+			(kind == tokenOperator && string(token) == "&&"))
+
+		switch {
+		case clauseEnd:
+			endTerm()
+			d.tokenizer = saved
+			return alwaysTrue || branchTrue
+		case isOr:
+			endTerm()
+			alwaysTrue = alwaysTrue || branchTrue
+			branchTrue = true
+		case isAnd:
+			endTerm()
+		default:
+			term = append(term, token)
+			termKinds = append(termKinds, kind)
+		}
+	}
+}
+
+// constantTrue check if term is constant true, like 1, true, 1 = 1 and 'a' = 'a'
+func constantTrue(kinds []int, term [][]byte) bool {
+	isLiteral := func(idx int) bool {
+		return kinds[idx] == tokenNumber || kinds[idx] == tokenString
+	}
+
+	switch len(term) {
+	case 1:
+		if kinds[0] == tokenWord {
+			return bytes.EqualFold(term[0], []byte("true"))
+		}
+		return kinds[0] == tokenNumber && len(bytes.Trim(term[0], "0.+-")) > 0
+	case 3:
+		if !isLiteral(0) || !isLiteral(2) || kinds[1] != tokenOperator {
+			return false
+		}
+		switch string(term[1]) {
+		case "=", "<=>", ">=", "<=":
+			return bytes.Equal(term[0], term[2])
+		case "<>", "!=":
+			return !bytes.Equal(term[0], term[2])
+		}
+	}
+	return false
+}
+
+// readAlterTable read name of table altered
+func (d *riskDetector) readAlterTable() {
+	kind, token := d.tokenizer.next()
+	if kind != tokenWord && kind != tokenQuotedIdent {
+		return
+	}
+	var db, table []byte
+	table = unquoteIdent(token)
+
+	saved := d.tokenizer
+	if kind, token = d.tokenizer.next(); kind == tokenPunct && token[0] == '.' {
+		kind, token = d.tokenizer.next()
+		if kind != tokenWord && kind != tokenQuotedIdent {
+			return
+		}
+		db, table = table, unquoteIdent(token)
+	} else {
+		d.tokenizer = saved
+	}
+	d.alterTables = append(d.alterTables, [2][]byte{db, table})
+}
+
+// hasIntoOutfile check if select writes file on server
+func (d *riskDetector) hasIntoOutfile() bool {
+	var prev []byte
+	for {
+		saved := d.tokenizer
+		kind, token := d.tokenizer.next()
+		if kind == tokenEOF || (kind == tokenPunct && token[0] == ';') {
+			d.tokenizer = saved
+			return false
+		}
+		if kind != tokenWord {
+			prev = nil
+			continue
+		}
+
+		if bytes.EqualFold(prev, []byte("into")) &&
+			(bytes.EqualFold(token, []byte("outfile")) || bytes.EqualFold(token, []byte("dumpfile"))) {
+			return true
+		}
+		prev = token
+	}
+}
+
+// checkRisk flag risky statement with rule IDs, risky query piece is sent synchronously
+func (ms *MysqlSession) checkRisk(mqp *model.PooledMysqlQueryPiece, querySQL []byte) {
+	if enabledRisks == nil {
+		return
+	}
+	if ms.riskDetector == nil {
+		ms.riskDetector = &riskDetector{}
+	}
+
+	detector := ms.riskDetector
+	risks := detector.detect(querySQL)
+	if len(detector.alterTables) > 0 && enabledRisks[RiskAlterLargeTable] &&
+		businessHours != nil && businessHours.contains(time.Now()) {
+		for _, alterTable := range detector.alterTables {
+			if ms.isLargeTable(alterTable[0], alterTable[1]) {
+				risks = append(risks, RiskAlterLargeTable)
+				break
+			}
+		}
+	}
+	if len(risks) < 1 {
+		return
+	}
+
+	mqp.Risk = append([]string(nil), risks...)
+	mqp.SetNeedSyncSend(true)
+	mqp.SetNeedRiskSend(true)
+}
+
+// isLargeTable check if table is larger than risk_large_table_mb, table size is queried
+// from server by admin user on admin query workers and cached, table of unknown size is
+// regarded as not large, like admin user is not set for strict mode or recover prepare,
+// or size is not received yet
+func (ms *MysqlSession) isLargeTable(db, table []byte) bool {
+	if len(db) < 1 && ms.visitDB != nil {
+		db = []byte(*ms.visitDB)
+	}
+	if (!strictMode && !recoverPrepare) || len(db) < 1 {
+		return false
+	}
+
+	key := fmt.Sprintf("%s/%s.%s", adminServerKey(*ms.serverIP, ms.serverPort), db, table)
+	tableSizeLock.Lock()
+	defer tableSizeLock.Unlock()
+	cached := tableSizes[key]
+	if cached == nil || time.Since(cached.queriedAt) > tableSizeTTL {
+		// size is unknown until query finished, and query is not sent again in the meantime
+		pending := &tableSize{size: -1, queriedAt: time.Now()}
+		serverIP, serverPort, dbName, tableName := *ms.serverIP, ms.serverPort, string(db), string(table)
+		if submitAdminQuery(func() { updateTableSize(key, pending, serverIP, serverPort, dbName, tableName) }) {
+			tableSizes[key] = pending
+		}
+	}
+	return cached != nil && cached.size >= int64(riskLargeTableMB)<<20
+}
+
+// updateTableSize query size of table and cache it, size stays unknown if query failed
+func updateTableSize(key string, pending *tableSize, serverIP string, serverPort int, db, table string) {
+	size, err := queryTableSize(serverIP, serverPort, db, table)
+
+	tableSizeLock.Lock()
+	defer tableSizeLock.Unlock()
+	if err == errAdminConnBackoff {
+		// query again with next alter table
+		if tableSizes[key] == pending {
+			delete(tableSizes, key)
+		}
+		return
+	} else if err != nil {
+		log.Warningf("query size of table %s failed <-- %s", key, err.Error())
+		return
+	}
+	pending.size = size
+}
+
+// queryTableSize query data and index size of table from information_schema, 0 if table not exists
+func queryTableSize(serverIP string, serverPort int, db, table string) (size int64, err error) {
+	adminConn, err := getAdminConn(serverIP, serverPort)
+	if err != nil {
+		return
+	}
+
+	var sizeVal sql.NullInt64
+	err = adminConn.QueryRow(
+		"SELECT DATA_LENGTH + INDEX_LENGTH FROM information_schema.TABLES "+
+			"WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?", db, table).Scan(&sizeVal)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		checkAdminConn(serverIP, serverPort, err)
+		return
+	}
+	return sizeVal.Int64, nil
+}
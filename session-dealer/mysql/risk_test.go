@@ -0,0 +1,144 @@
+package mysql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRiskRules(t *testing.T) {
+	risks, err := parseRiskRules(" Drop_Table, truncate_table ")
+	want := map[string]bool{RiskDropTable: true, RiskTruncateTable: true}
+	if err != nil || !reflect.DeepEqual(risks, want) {
+		t.Errorf("got %v %v, want %v", risks, err, want)
+	}
+
+	if risks, err = parseRiskRules("all"); err != nil || len(risks) != len(riskRuleIDs) {
+		t.Errorf("all rules got %v %v", risks, err)
+	}
+	if risks, err = parseRiskRules(""); err != nil || risks != nil {
+		t.Errorf("no rule got %v %v, want risk detection disabled", risks, err)
+	}
+	if _, err = parseRiskRules("drop_table,drop_user"); err == nil || !strings.Contains(err.Error(), "drop_user") {
+		t.Errorf("unknown rule got error %v", err)
+	}
+}
+
+func TestParseBusinessHours(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2020, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+
+	hours, err := parseBusinessHours("09:00-18:30")
+	if err != nil || !hours.contains(at(9, 0)) || !hours.contains(at(18, 29)) ||
+		hours.contains(at(18, 30)) || hours.contains(at(8, 59)) {
+		t.Errorf("day range got %+v %v", hours, err)
+	}
+
+	hours, err = parseBusinessHours("22:00-06:00")
+	if err != nil || !hours.contains(at(23, 0)) || !hours.contains(at(5, 59)) || hours.contains(at(12, 0)) {
+		t.Errorf("overnight range got %+v %v", hours, err)
+	}
+
+	if hours, err = parseBusinessHours(" "); err != nil || hours != nil {
+		t.Errorf("empty range got %+v %v", hours, err)
+	}
+	for _, invalid := range []string{"9-18", "09:60-18:00", "25:00-06:00", "09:00"} {
+		if _, err = parseBusinessHours(invalid); err == nil {
+			t.Errorf("invalid range %s got no error", invalid)
+		}
+	}
+}
+
+func TestRiskDetect(t *testing.T) {
+	defer func(risks map[string]bool) {
+		enabledRisks = risks
+	}(enabledRisks)
+	enabledRisks, _ = parseRiskRules("all")
+
+	cases := []struct {
+		sql   string
+		risks []string
+	}{
+		{"delete from t", []string{RiskDeleteWithoutWhere}},
+		{"delete from t where id = 1", nil},
+		{"delete from t where id in (select id from t2) ", nil},
+		{"DELETE FROM t WHERE 1=1", []string{RiskAlwaysTrueWhere}},
+		{"delete from t where id = 1 or 'a' = 'a' limit 10", []string{RiskAlwaysTrueWhere}},
+		{"delete from t where 1 and id = 2", nil},
+		{"delete from t where true and 2 <> 3", []string{RiskAlwaysTrueWhere}},
+		{"delete from t where 0", nil},
+		{"update t set a = 1", []string{RiskUpdateWithoutWhere}},
+		{"update t set a = (select 1 from t2 where id = 1)", []string{RiskUpdateWithoutWhere}},
+		{"with c as (select 1) update t set a = 1 order by id", []string{RiskUpdateWithoutWhere}},
+		{"update t set a = 1 where id = 1 || 1", []string{RiskAlwaysTrueWhere}},
+		{"drop table if exists t", []string{RiskDropTable}},
+		{"drop database db1", []string{RiskDropDatabase}},
+		{"drop index i on t", nil},
+		{"truncate t", []string{RiskTruncateTable}},
+		{"select * from t into outfile '/tmp/t'", []string{RiskSelectIntoOutfile}},
+		{"select 'into outfile' from t", nil},
+		{"grant all privileges on *.* to u", []string{RiskGrantAll}},
+		{"grant select on db.* to u", nil},
+		{"update t set a = 1 where id = 1; delete from t; drop table t2", []string{RiskDeleteWithoutWhere, RiskDropTable}},
+		{"delete from t; delete from t2", []string{RiskDeleteWithoutWhere}},
+	}
+
+	var detector riskDetector
+	for _, c := range cases {
+		if risks := detector.detect([]byte(c.sql)); len(risks) != len(c.risks) ||
+			(len(risks) > 0 && !reflect.DeepEqual(risks, c.risks)) {
+			t.Errorf("detect %q got %v, want %v", c.sql, risks, c.risks)
+		}
+	}
+
+	// only enabled rules are checked
+	enabledRisks, _ = parseRiskRules("drop_table")
+	if risks := detector.detect([]byte("delete from t; drop table t")); !reflect.DeepEqual(risks, []string{RiskDropTable}) {
+		t.Errorf("detect with drop_table enabled got %v", risks)
+	}
+}
+
+func TestCheckRisk(t *testing.T) {
+	defer func(risks map[string]bool, hours *minuteRange, strict bool, largeMB int) {
+		enabledRisks, businessHours, strictMode, riskLargeTableMB = risks, hours, strict, largeMB
+	}(enabledRisks, businessHours, strictMode, riskLargeTableMB)
+	enabledRisks, _ = parseRiskRules("all")
+	businessHours, _ = parseBusinessHours("00:00-24:00")
+	strictMode, riskLargeTableMB = true, 1
+
+	// table size is cached already, so admin connection is not used
+	tableSizeLock.Lock()
+	tableSizes["10.0.0.1:3306/db1.big"] = &tableSize{size: 2 << 20, queriedAt: time.Now()}
+	tableSizes["10.0.0.1:3306/db1.small"] = &tableSize{size: 1 << 10, queriedAt: time.Now()}
+	tableSizeLock.Unlock()
+
+	ts := newTestSession()
+	ts.query("use db1", okPacket(0, ServerStatusAutocommit))
+	ts.piece()
+
+	cases := []struct {
+		sql   string
+		risks []string
+	}{
+		{"alter table big add column c int", []string{RiskAlterLargeTable}},
+		{"alter table db1.small add column c int", nil},
+		{"delete from small", []string{RiskDeleteWithoutWhere}},
+		{"select * from small", nil},
+	}
+	for _, c := range cases {
+		ts.query(c.sql, okPacket(0, ServerStatusAutocommit))
+		piece := ts.piece()
+		if piece == nil {
+			t.Fatalf("%s got no piece", c.sql)
+		}
+		// risky piece is sent synchronously, others may be sent synchronously by sync rules
+		risky := len(c.risks) > 0
+		if len(piece.Risk) != len(c.risks) || (risky && !reflect.DeepEqual(piece.Risk, c.risks)) ||
+			piece.RiskSend != risky || (risky && !piece.NeedSyncSend()) {
+			t.Errorf("%s got risks %v sync %v risk send %v, want %v", c.sql, piece.Risk, piece.NeedSyncSend(),
+				piece.RiskSend, c.risks)
+		}
+	}
+}
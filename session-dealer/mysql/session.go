@@ -1,6 +1,8 @@
 package mysql
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
@@ -15,6 +17,22 @@ type MysqlSession struct {
 	connectionID      *string
 	visitUser         *string
 	visitDB           *string
+	capability        uint32
+	collation         uint16
+	// charset is the client charset of session, sql and params are transcoded from it to utf8
+	charset           string
+	serverVersion     *string
+	serverThreadID    uint32
+	serverCapability  uint32
+	authPlugin        *string
+	phase             int
+	// pendingChangeUser is COM_CHANGE_USER waiting for the final OK of auth exchange
+	pendingChangeUser *handshakeResponse41
+	// clientDataSeen is set when client send any data, server greeting only come before it
+	clientDataSeen    bool
+	connAttrs         map[string]string
+	// exportConnAttrs is the encoded connection attributes in whitelist, shared by all query piece
+	exportConnAttrs   []byte
 	clientIP          *string
 	clientPort        int
 	serverIP          *string
@@ -23,11 +41,38 @@ type MysqlSession struct {
 	// packageOffset            int64
 	beginSeqID               int64
 	endSeqID                 int64
+	// headerBuffer collect header of packet in statement, which may be split into tcp packets
+	headerBuffer             [4]byte
+	headerIdx                int64
+	headerMask               byte
 	coverRanges              *coverRanges
 	expectReceiveSize        int
 	expectSendSize           int
+	// serverRespType is the first byte of server response, -1 means not received
+	serverRespType           int
+	// response follow server packets of commands may return several results
+	response                 *responseTracker
 	prepareInfo              *prepareInfo
-	cachedPrepareStmt        map[int][]byte
+	cachedPrepareStmt        map[int]*preparedStatement
+	// prepareRecoveries is lookup state of prepared statements not cached, keyed by statement id
+	prepareRecoveries        map[int]*prepareLookup
+	// replica is the info registered by COM_REGISTER_SLAVE
+	replica                  *model.BinlogInfo
+	binlogStream             *binlogStream
+	// trx is the transaction in progress, trxSeq generate transaction id in session
+	trx                      *transaction
+	trxSeq                   int64
+	// trxSummary is summary of finished transaction, it's sent after the statement ending transaction
+	trxSummary               *model.PooledMysqlQueryPiece
+	// sessionInfoResult receive result of session info lookup in strict mode
+	sessionInfoResult        chan *sessionInfo
+	sessionInfoQueried       bool
+	// sessionVars is snapshot of tracked session variables, shared by query pieces
+	sessionVars              *model.SessionVars
+	// fingerprinter normalize sql of query pieces, reused to avoid allocation
+	fingerprinter            *fingerprinter
+	literalMasker            *literalMasker
+	riskDetector             *riskDetector
 	cachedStmtBytes          []byte
 	computeWindowSizeCounter int
 
@@ -41,10 +86,6 @@ type MysqlSession struct {
 	sendSize    int64
 }
 
-type prepareInfo struct {
-	prepareStmtID int
-}
-
 func NewMysqlSession(
 	sessionKey, clientIP *string, clientPort int, serverIP *string, serverPort int,
 	receiver chan model.QueryPiece) (ms *MysqlSession) {
@@ -55,10 +96,11 @@ func NewMysqlSession(
 		serverIP:           serverIP,
 		serverPort:         serverPort,
 		stmtBeginTimeNano:  time.Now().UnixNano(),
-		cachedPrepareStmt:  make(map[int][]byte, 8),
+		cachedPrepareStmt:  make(map[int]*preparedStatement, 8),
 		queryPieceReceiver: receiver,
 		closeConn:          make(chan bool, 1),
 		expectReceiveSize:  -1,
+		serverRespType:     -1,
 		coverRanges:        NewCoverRanges(),
 		ignoreAckID:        -1,
 		sendSize:           0,
@@ -72,6 +114,36 @@ func (ms *MysqlSession) ReceiveTCPPacket(newPkt *model.TCPPacket) {
 	if newPkt == nil {
 		return
 	}
+	if newPkt.ToServer {
+		ms.clientDataSeen = true
+	}
+
+	switch ms.phase {
+	case phaseSSL:
+		// data is encrypted, nothing can be parsed
+		return
+
+	case phaseAuth:
+		if !newPkt.ToServer {
+			ms.readAuthResponse(newPkt.Payload)
+			return
+		}
+
+		// client packets in auth exchange are auth data, never parse them as command,
+		// except command packet with sequence id 0, which means the final OK is missed
+		if len(newPkt.Payload) < 4 || newPkt.Payload[3] != 0 {
+			return
+		}
+		ms.phase = phaseCommand
+	}
+
+	if ms.binlogStream != nil {
+		// replica only send semi-sync ack in binlog stream, never parse it as command
+		if !newPkt.ToServer {
+			ms.readBinlogStream(newPkt.Payload)
+		}
+		return
+	}
 
 	if !newPkt.ToServer && ms.ignoreAckID == newPkt.Seq {
 		// ignore to response to client data
@@ -82,44 +154,127 @@ func (ms *MysqlSession) ReceiveTCPPacket(newPkt *model.TCPPacket) {
 		ms.ignoreAckID = newPkt.Seq + int64(len(newPkt.Payload))
 	}
 
-	if newPkt.ToServer {
+	if newPkt.ToServer && ms.response != nil && ms.response.readingInfile() {
+		// file content of LOAD DATA LOCAL INFILE, never parse it as command
+		ms.response.readInfile(newPkt.Payload)
+
+	} else if newPkt.ToServer {
+		// new command begin before the last response finished, maybe some server packets are lost
+		if ms.response != nil {
+			ms.sendQueryPiece(ms.GenerateQueryPiece())
+		}
 		ms.resetBeginTime()
-		ms.readFromClient(newPkt.Seq, newPkt.Payload)
+		ms.readClientPayload(newPkt.Seq, newPkt.Payload)
 
 	} else {
 		ms.readFromServer(newPkt.Seq, newPkt.Payload)
-		qp := ms.GenerateQueryPiece()
-		if qp != nil {
-			ms.queryPieceReceiver <- qp
+		if ms.response != nil && !ms.response.finished() {
+			return
 		}
+		ms.sendQueryPiece(ms.GenerateQueryPiece())
+	}
+}
+
+func (ms *MysqlSession) sendQueryPiece(qp model.QueryPiece) {
+	if qp != nil {
+		ms.queryPieceReceiver <- qp
 	}
+	ms.sendTrxSummary()
 }
 
 func (ms *MysqlSession) resetBeginTime() {
 	ms.stmtBeginTimeNano = time.Now().UnixNano()
 }
 
+// ExpectGreeting check if server greeting may come, packet like greeting after
+// client send data is not greeting, like result row of 10 bytes string with sequence id 0
+func (ms *MysqlSession) ExpectGreeting() bool {
+	return !ms.clientDataSeen
+}
+
 func (ms *MysqlSession) readFromServer(respSeq int64, bytes []byte) {
+	if ms.ExpectGreeting() && len(bytes) > 4 && IsGreeting(bytes[:4], bytes[4]) {
+		ms.readGreeting(bytes)
+		return
+	}
+
+	if ms.response == nil && ms.expectSendSize < 1 &&
+		len(ms.cachedStmtBytes) > 0 && isTrackedCommand(ms.cachedStmtBytes[0]) {
+		ms.response = newResponseTracker(ms.cachedStmtBytes[0])
+	}
+
 	if ms.expectSendSize < 1 && len(bytes) > 4 {
 		ms.expectSendSize = extractMysqlPayloadSize(bytes[:4])
-		contents := bytes[4:]
-		if ms.prepareInfo != nil && contents[0] == 0 {
-			ms.prepareInfo.prepareStmtID = bytesToInt(contents[1:5])
+		ms.serverRespType = int(bytes[4])
+		// change user may trigger auth exchange as handshake
+		if len(ms.cachedStmtBytes) > 0 && ms.cachedStmtBytes[0] == ComChangeUser {
+			ms.readAuthResponse(bytes)
+		}
+		if ms.prepareInfo != nil {
+			ms.prepareInfo.parseResponse(bytes)
 		}
 	}
 
-	if ms.coverRanges.head.next == nil || ms.coverRanges.head.next.end != respSeq {
+	if ms.response != nil {
+		ms.response.read(bytes)
+	}
+
+	if ms.coverRanges.head.next == nil || ms.coverRanges.head.next.end+ms.infileStreamLen() != respSeq {
 		ms.clear()
 	}
 }
 
+// infileStreamLen return length of file content send after statement, server ack include it
+func (ms *MysqlSession) infileStreamLen() int64 {
+	if ms.response == nil {
+		return 0
+	}
+	return ms.response.infileStreamLen
+}
+
+func (ms *MysqlSession) expectNoResponse() bool {
+	if len(ms.cachedStmtBytes) < 1 {
+		return false
+	}
+
+	switch ms.cachedStmtBytes[0] {
+	case ComStmtSendLongData, ComStmtClose, ComQuit:
+		return true
+	default:
+		return false
+	}
+}
+
+// readGreeting get server version and connection id from the initial handshake
+func (ms *MysqlSession) readGreeting(bytes []byte) {
+	payloadSize := extractMysqlPayloadSize(bytes[:4])
+	if len(bytes) < 4+payloadSize {
+		log.Warning("receive a not complete handshake packet")
+		return
+	}
+
+	greeting := new(handshakeV10)
+	if err := parseHandshakeV10(greeting, bytes[4:4+payloadSize]); err != nil {
+		log.Errorf("parse handshake packet failed <-- %s", err.Error())
+		return
+	}
+
+	ms.serverVersion = &greeting.ServerVersion
+	ms.serverThreadID = greeting.ConnectionID
+	ms.serverCapability = greeting.Capability
+	if len(greeting.AuthPlugin) > 0 {
+		ms.authPlugin = &greeting.AuthPlugin
+	}
+	ms.phase = phaseHandshake
+}
+
 func (ms *MysqlSession) checkFinish() bool {
-	if ms.coverRanges.head == nil || ms.coverRanges.head.next == nil {
+	if ms.coverRanges.head == nil || ms.coverRanges.head.next == nil || ms.endSeqID < 0 {
 		return false
 	}
 
 	checkNode := ms.coverRanges.head.next
-	if checkNode.end-checkNode.begin == int64(len(ms.cachedStmtBytes)) {
+	if checkNode.begin == ms.beginSeqID && checkNode.end >= ms.endSeqID {
 		return true
 	}
 
@@ -127,15 +282,26 @@ func (ms *MysqlSession) checkFinish() bool {
 }
 
 func (ms *MysqlSession) Close() {
+	ms.finishTransaction(TrxOutcomeDisconnect)
+	ms.sendTrxSummary()
+	if ms.binlogStream != nil && ms.binlogStream.bytes > 0 {
+		ms.sendQueryPiece(ms.reportBinlogStream())
+	}
 	ms.clear()
 }
 
 func (ms *MysqlSession) clear() {
-	localStmtCache.Enqueue(ms.cachedStmtBytes)
+	// piece of not finished response is flushed when next command begin, and its sql
+	// still refer to the statement cache, so leave the cache to gc instead of reuse it
+	if ms.response == nil || ms.response.finished() {
+		localStmtCache.Enqueue(ms.cachedStmtBytes)
+	}
 	ms.cachedStmtBytes = nil
 	ms.expectReceiveSize = -1
 	ms.expectSendSize = -1
+	ms.serverRespType = -1
 	ms.prepareInfo = nil
+	ms.response = nil
 	ms.beginSeqID = -1
 	ms.endSeqID = -1
 	ms.ignoreAckID = -1
@@ -143,9 +309,34 @@ func (ms *MysqlSession) clear() {
 	ms.coverRanges.clear()
 }
 
-func (ms *MysqlSession) readFromClient(seqID int64, bytes []byte) {
-	contentSize := int64(len(bytes))
+// readClientPayload read tcp payload from client, one payload may contain several mysql packets
+func (ms *MysqlSession) readClientPayload(seqID int64, payload []byte) {
+	for len(payload) > 0 {
+		rest := ms.readFromClient(seqID, payload)
+		if ms.isHandshakeResponse() && ms.checkFinish() {
+			ms.readHandshakeResponse()
+
+		} else if ms.expectNoResponse() && ms.checkFinish() {
+			// server send nothing back for some commands, deal them at once
+			ms.sendQueryPiece(ms.GenerateQueryPiece())
+
+		} else if len(rest) > 0 {
+			log.Infof("in session %s ignore %d bytes after a not finished packet",
+				*ms.connectionID, len(rest))
+			return
+		}
+
+		if ms.phase != phaseCommand && ms.phase != phaseHandshake {
+			return
+		}
+		seqID += int64(len(payload) - len(rest))
+		payload = rest
+	}
+}
 
+// readFromClient add tcp payload to the statement cache, mysql packets longer than MaxPayloadLen
+// are stitched together, and the part beyond cache size is dropped, return the bytes after statement
+func (ms *MysqlSession) readFromClient(seqID int64, bytes []byte) (rest []byte) {
 	if ms.expectReceiveSize == -1 {
 		// ignore invalid head package
 		if len(bytes) <= 4 {
@@ -153,64 +344,114 @@ func (ms *MysqlSession) readFromClient(seqID int64, bytes []byte) {
 		}
 
 		ms.expectReceiveSize = extractMysqlPayloadSize(bytes[:4])
-		// ignore too big mysql packet
-		if ms.expectReceiveSize >= MaxMySQLPacketLen {
-			log.Infof("expect receive size is bigger than max deal size: %d", MaxMySQLPacketLen)
-			return
-		}
+		ms.beginSeqID = seqID
+		ms.endSeqID = -1
+		ms.headerIdx = -1
+		ms.readPacketHeader(0, 0, bytes[:4])
 
-		contents := bytes[4:]
 		// add prepare info
-		if contents[0] == ComStmtPrepare {
+		if bytes[4] == ComStmtPrepare {
 			ms.prepareInfo = &prepareInfo{}
 		}
 
-		contentSize = int64(len(contents))
-		seqID += 4
-		ms.beginSeqID = seqID
-		ms.endSeqID = seqID
-
-		if int64(ms.expectReceiveSize) < int64(len(contents)) {
-			log.Warning("receive invalid mysql packet")
-			return
+		// only cache the beginning of too long statement, statement split into packets
+		// is cached up to max length, cache is cut to statement length at the last packet
+		cacheSize := ms.expectReceiveSize
+		if cacheSize >= MaxPayloadLen {
+			cacheSize = MaxMySQLPacketLen
 		}
+		if cacheSize >= MaxMySQLPacketLen {
+			log.Infof("expect receive size %d is bigger than max deal size: %d, truncate it",
+				ms.expectReceiveSize, MaxMySQLPacketLen)
+			cacheSize = MaxMySQLPacketLen - 1
+		}
+		ms.cachedStmtBytes = localStmtCache.DequeueWithInit(cacheSize)
 
-		newCache := localStmtCache.DequeueWithInit(ms.expectReceiveSize)
-		copy(newCache[:len(contents)], contents)
-		ms.cachedStmtBytes = newCache
+	} else if ms.beginSeqID == -1 {
+		log.Info("cover range is empty")
+		return
 
-	} else {
-		// ignore too big mysql packet
-		if ms.expectReceiveSize >= MaxMySQLPacketLen {
+	} else if seqID < ms.beginSeqID {
+		// out date packet
+		log.Infof("in session %s get outdate package with Seq:%d, beginSeq:%d",
+			*ms.connectionID, seqID, ms.beginSeqID)
+		return
+	}
+
+	// split the bytes belong to next packet
+	if ms.endSeqID > 0 && seqID+int64(len(bytes)) > ms.endSeqID {
+		if seqID >= ms.endSeqID {
+			log.Info("receive an unexpect packet")
+			ms.clear()
 			return
 		}
+		rest = bytes[ms.endSeqID-seqID:]
+		bytes = bytes[:ms.endSeqID-seqID]
+	}
 
-		if ms.beginSeqID == -1 {
-			log.Info("cover range is empty")
-			return
+	ms.copyToStmtCache(seqID-ms.beginSeqID, bytes)
+	ms.coverRanges.addRange(coverRangePool.NewCoverage(seqID, seqID+int64(len(bytes))))
+	return
+}
+
+// copyToStmtCache copy bytes at stream offset into statement cache, skip the packet headers.
+// All packets before the last one are MaxPayloadLen long, so the position of every header is fixed
+func (ms *MysqlSession) copyToStmtCache(streamOffset int64, bytes []byte) {
+	const fullPacketLen = int64(MaxPayloadLen) + 4
+	for len(bytes) > 0 {
+		packetIdx := streamOffset / fullPacketLen
+		packetOffset := streamOffset % fullPacketLen
+		if packetOffset < 4 {
+			size := minInt64(4-packetOffset, int64(len(bytes)))
+			ms.readPacketHeader(packetIdx, packetOffset, bytes[:size])
+			streamOffset += size
+			bytes = bytes[size:]
+			continue
 		}
 
-		if seqID < ms.beginSeqID {
-			// out date packet
-			log.Infof("in session %s get outdate package with Seq:%d, beginSeq:%d",
-				*ms.connectionID, seqID, ms.beginSeqID)
-			return
+		size := minInt64(fullPacketLen-packetOffset, int64(len(bytes)))
+		cacheOffset := packetIdx*int64(MaxPayloadLen) + packetOffset - 4
+		if cacheOffset < int64(len(ms.cachedStmtBytes)) {
+			copy(ms.cachedStmtBytes[cacheOffset:], bytes[:size])
 		}
+		streamOffset += size
+		bytes = bytes[size:]
+	}
+}
 
-		seqOffset := seqID - ms.beginSeqID
-		if seqOffset+contentSize > int64(len(ms.cachedStmtBytes)) {
-			// not in a normal mysql packet
-			log.Info("receive an unexpect packet")
-			ms.clear()
-			return
+// readPacketHeader collect header bytes of the packetIdx-th packet in statement,
+// when the header is complete and it's the last packet, the end of statement is known
+func (ms *MysqlSession) readPacketHeader(packetIdx, headerOffset int64, bytes []byte) {
+	if packetIdx != ms.headerIdx {
+		ms.headerIdx = packetIdx
+		ms.headerMask = 0
+	}
+
+	for i := range bytes {
+		ms.headerBuffer[headerOffset+int64(i)] = bytes[i]
+		ms.headerMask |= 1 << uint(headerOffset+int64(i))
+	}
+	if ms.headerMask != 0x0f {
+		return
+	}
+
+	payloadSize := extractMysqlPayloadSize(ms.headerBuffer[:])
+	if payloadSize < MaxPayloadLen {
+		ms.endSeqID = ms.beginSeqID + packetIdx*(int64(MaxPayloadLen)+4) + 4 + int64(payloadSize)
+		if stmtLen := ms.stmtPayloadLen(); stmtLen < int64(len(ms.cachedStmtBytes)) {
+			ms.cachedStmtBytes = ms.cachedStmtBytes[:stmtLen]
 		}
+	}
+}
 
-		// add byte to stmt cache
-		copy(ms.cachedStmtBytes[seqOffset:seqOffset+contentSize], bytes)
+// stmtPayloadLen is the total payload length of statement, without packet headers
+func (ms *MysqlSession) stmtPayloadLen() int64 {
+	if ms.endSeqID < 0 {
+		return int64(len(ms.cachedStmtBytes))
 	}
 
-	ms.coverRanges.addRange(coverRangePool.NewCoverage(seqID, seqID+contentSize))
-	// ms.expectReceiveSize = ms.expectReceiveSize - int(contentSize)
+	streamLen := ms.endSeqID - ms.beginSeqID
+	return streamLen - 4*(streamLen/(int64(MaxPayloadLen)+4)+1)
 }
 
 func IsAuth(val byte) bool {
@@ -229,107 +470,389 @@ func (ms *MysqlSession) GenerateQueryPiece() (qp model.QueryPiece) {
 		return
 	}
 
-	if len(ms.cachedStmtBytes) > maxSQLLen {
-		log.Warning("sql in cache is too long, ignore it")
-		return
-	}
+	ms.checkSessionInfo()
 
 	var mqp *model.PooledMysqlQueryPiece
 	var querySQLInBytes []byte
-	if IsAuth(ms.cachedStmtBytes[0]) {
-		userName, dbName, err := parseAuthInfo(ms.cachedStmtBytes)
-		if err != nil {
-			log.Errorf("parse auth info failed <-- %s", err.Error())
+	command := ms.cachedStmtBytes[0]
+	switch command {
+	case ComInitDB:
+		newDBName := string(ms.toUTF8(ms.cachedStmtBytes[1:]))
+		useSQL := fmt.Sprintf("use %s", newDBName)
+		querySQLInBytes = hack.Slice(useSQL)
+		mqp = ms.composeQueryPiece()
+		mqp.QuerySQL = &useSQL
+		// update session database
+		ms.visitDB = &newDBName
+
+	case ComDropDB:
+		dbName := string(ms.toUTF8(ms.cachedStmtBytes[1:]))
+		dropSQL := fmt.Sprintf("drop database %s", dbName)
+		querySQLInBytes = hack.Slice(dropSQL)
+		mqp = ms.composeQueryPiece()
+		mqp.QuerySQL = &dropSQL
+
+	case ComCreateDB, ComQuery:
+		mqp = ms.composeQueryPiece()
+		querySQLInBytes = ms.toUTF8(ms.cachedStmtBytes[1:])
+		querySQL := hack.String(querySQLInBytes)
+		mqp.QuerySQL = &querySQL
+		if splitMultiStatements {
+			if stmts := splitStatements(querySQLInBytes); len(stmts) > 1 {
+				mqp.Statements = stmts
+			}
+		}
+		if charset, ok := parseSetCharset(querySQLInBytes); ok && ms.serverRespType != int(ErrHeader) {
+			ms.charset = charset
+		}
+
+	case ComStmtPrepare:
+		mqp = ms.composeQueryPiece()
+		// statement is cached in session, must not refer to the packet cache
+		querySQLInBytes = append([]byte(nil), ms.toUTF8(ms.cachedStmtBytes[1:])...)
+		querySQL := hack.String(querySQLInBytes)
+		mqp.QuerySQL = &querySQL
+		ms.cachedPrepareStmt[ms.prepareInfo.prepareStmtID] = &preparedStatement{
+			sql:        querySQLInBytes,
+			paramCount: ms.prepareInfo.paramCount,
+			paramTypes: ms.prepareInfo.paramTypes,
+		}
+		delete(ms.prepareRecoveries, ms.prepareInfo.prepareStmtID)
+		log.Infof("prepare statement %s, get id:%d", querySQL, ms.prepareInfo.prepareStmtID)
+
+	case ComStmtExecute:
+		prepareStmtID := bytesToInt(ms.cachedStmtBytes[1:5])
+		mqp = ms.composeQueryPiece()
+		mqp.StmtID = &prepareStmtID
+		stmt, ok := ms.getPreparedStatement(prepareStmtID)
+		if ok {
+			querySQLInBytes = stmt.sql
+			ms.fillExecuteParams(mqp, stmt)
+			if ms.response != nil && ms.response.finished() &&
+				ms.response.status&ServerStatusCursorExists > 0 {
+				stmt.cursorBeginNano = ms.stmtBeginTimeNano
+			}
+		} else {
+			querySQLInBytes = PrepareStatement
+		}
+		querySQL := hack.String(querySQLInBytes)
+		mqp.QuerySQL = &querySQL
+
+		// log.Debugf("execute prepare statement:%d", prepareStmtID)
+
+	case ComStmtSendLongData:
+		// chunk of param is only accumulated, it's output with params of the next execution
+		prepareStmtID := bytesToInt(ms.cachedStmtBytes[1:5])
+		stmt, ok := ms.getPreparedStatement(prepareStmtID)
+		if ok && len(ms.cachedStmtBytes) >= 7 {
+			paramID := int(binary.LittleEndian.Uint16(ms.cachedStmtBytes[5:7]))
+			stmt.appendLongData(paramID, ms.cachedStmtBytes[7:])
+		}
+		return
+
+	case ComStmtReset:
+		prepareStmtID := bytesToInt(ms.cachedStmtBytes[1:5])
+		mqp = ms.composeQueryPiece()
+		mqp.StmtID = &prepareStmtID
+		stmt, ok := ms.cachedPrepareStmt[prepareStmtID]
+		querySQLInBytes = PrepareStatement
+		if ok {
+			querySQLInBytes = stmt.sql
+			stmt.longData = nil
+			stmt.cursorBeginNano = 0
+		}
+		querySQL := hack.String(querySQLInBytes)
+		mqp.QuerySQL = &querySQL
+
+	case ComStmtFetch:
+		prepareStmtID := bytesToInt(ms.cachedStmtBytes[1:5])
+		mqp = ms.composeQueryPiece()
+		mqp.StmtID = &prepareStmtID
+		stmt, ok := ms.getPreparedStatement(prepareStmtID)
+		if ok {
+			querySQLInBytes = stmt.sql
+			mqp.CursorBT = stmt.cursorBeginNano / millSecondUnit
+		} else {
+			querySQLInBytes = PrepareStatement
+		}
+		querySQL := hack.String(querySQLInBytes)
+		mqp.QuerySQL = &querySQL
+		if len(ms.cachedStmtBytes) >= 9 {
+			requestedRows := int64(bytesToInt(ms.cachedStmtBytes[5:9]))
+			mqp.RequestedRows = &requestedRows
+		}
+
+	case ComStmtClose:
+		prepareStmtID := bytesToInt(ms.cachedStmtBytes[1:5])
+		mqp = ms.composeQueryPiece()
+		mqp.StmtID = &prepareStmtID
+		querySQLInBytes = PrepareStatement
+		if stmt, ok := ms.cachedPrepareStmt[prepareStmtID]; ok {
+			querySQLInBytes = stmt.sql
+		}
+		querySQL := hack.String(querySQLInBytes)
+		mqp.QuerySQL = &querySQL
+		delete(ms.cachedPrepareStmt, prepareStmtID)
+		delete(ms.prepareRecoveries, prepareStmtID)
+		log.Infof("remove prepare statement:%d", prepareStmtID)
+
+	case ComChangeUser:
+		changeUser := &handshakeResponse41{Capability: ms.sessionCapability()}
+		if err := parseChangeUser(changeUser, ms.cachedStmtBytes[1:]); err != nil {
+			log.Errorf("parse change user packet failed <-- %s", err.Error())
 			return
 		}
-		ms.visitUser = &userName
-		ms.visitDB = &dbName
 
-	} else {
-		switch ms.cachedStmtBytes[0] {
-		case ComInitDB:
-			newDBName := string(ms.cachedStmtBytes[1:])
-			useSQL := fmt.Sprintf("use %s", newDBName)
-			querySQLInBytes = hack.Slice(useSQL)
-			mqp = ms.composeQueryPiece()
-			mqp.QuerySQL = &useSQL
-			// update session database
-			ms.visitDB = &newDBName
-
-		case ComDropDB:
-			dbName := string(ms.cachedStmtBytes[1:])
-			dropSQL := fmt.Sprintf("drop database %s", dbName)
-			mqp = ms.composeQueryPiece()
-			mqp.QuerySQL = &dropSQL
-
-		case ComCreateDB, ComQuery:
-			mqp = ms.composeQueryPiece()
-			querySQLInBytes = ms.cachedStmtBytes[1:]
-			querySQL := hack.String(querySQLInBytes)
-			mqp.QuerySQL = &querySQL
-
-		case ComStmtPrepare:
-			mqp = ms.composeQueryPiece()
-			querySQLInBytes = make([]byte, len(ms.cachedStmtBytes[1:]))
-			copy(querySQLInBytes, ms.cachedStmtBytes[1:])
-			querySQL := hack.String(querySQLInBytes)
-			mqp.QuerySQL = &querySQL
-			ms.cachedPrepareStmt[ms.prepareInfo.prepareStmtID] = querySQLInBytes
-			log.Infof("prepare statement %s, get id:%d", querySQL, ms.prepareInfo.prepareStmtID)
-
-		case ComStmtExecute:
-			prepareStmtID := bytesToInt(ms.cachedStmtBytes[1:5])
-			mqp = ms.composeQueryPiece()
-			var ok bool
-			querySQLInBytes, ok = ms.cachedPrepareStmt[prepareStmtID]
-			if !ok {
-				querySQLInBytes = PrepareStatement
+		changeUserSQL := fmt.Sprintf("change user %s", changeUser.User)
+		querySQLInBytes = hack.Slice(changeUserSQL)
+		mqp = ms.composeQueryPiece()
+		mqp.QuerySQL = &changeUserSQL
+		// change user failed, session keep the old user. With auth exchange,
+		// the result is known when server send the final OK or ERR
+		if ms.phase == phaseAuth {
+			ms.pendingChangeUser = changeUser
+		} else if ms.serverRespType != int(ErrHeader) {
+			ms.applyChangeUser(changeUser)
+			if len(changeUser.AuthPlugin) > 0 {
+				ms.authPlugin = &changeUser.AuthPlugin
 			}
-			querySQL := hack.String(querySQLInBytes)
-			mqp.QuerySQL = &querySQL
+		}
+
+	case ComRegisterSlave:
+		replica, err := parseRegisterSlave(ms.cachedStmtBytes[1:])
+		if err != nil {
+			log.Errorf("parse register slave packet failed <-- %s", err.Error())
+			return
+		}
+
+		registerSQL := "register slave"
+		querySQLInBytes = hack.Slice(registerSQL)
+		mqp = ms.composeQueryPiece()
+		mqp.QuerySQL = &registerSQL
+		mqp.Binlog = replica
+		if ms.serverRespType != int(ErrHeader) {
+			ms.replica = replica
+		}
+
+	case ComBinlogDump, ComBinlogDumpGtid:
+		var dump *model.BinlogInfo
+		var err error
+		if ms.cachedStmtBytes[0] == ComBinlogDump {
+			dump, err = parseBinlogDump(ms.cachedStmtBytes[1:])
+		} else {
+			dump, err = parseBinlogDumpGTID(ms.cachedStmtBytes[1:])
+		}
+		if err != nil {
+			log.Errorf("parse binlog dump packet failed <-- %s", err.Error())
+			return
+		}
+		if ms.replica != nil && ms.replica.ServerID == dump.ServerID {
+			dump.ReportHost = ms.replica.ReportHost
+			dump.ReportPort = ms.replica.ReportPort
+		}
 
-			// log.Debugf("execute prepare statement:%d", prepareStmtID)
+		dumpSQL := fmt.Sprintf("binlog dump %s:%d", dump.File, dump.Pos)
+		if len(dump.GTIDSet) > 0 {
+			dumpSQL = fmt.Sprintf("binlog dump gtid %s", dump.GTIDSet)
+		}
+		querySQLInBytes = hack.Slice(dumpSQL)
+		mqp = ms.composeQueryPiece()
+		mqp.QuerySQL = &dumpSQL
+		mqp.Binlog = dump
+		if ms.serverRespType != int(ErrHeader) {
+			log.Infof("session %s begin binlog dump from %s", *ms.connectionID, dumpSQL)
+			ms.binlogStream = newBinlogStream(ms.cachedStmtBytes[0], dump)
+		}
 
-		case ComStmtClose:
-			prepareStmtID := bytesToInt(ms.cachedStmtBytes[1:5])
-			delete(ms.cachedPrepareStmt, prepareStmtID)
-			log.Infof("remove prepare statement:%d", prepareStmtID)
+	case ComResetConnection:
+		resetSQL := "reset connection"
+		querySQLInBytes = hack.Slice(resetSQL)
+		mqp = ms.composeQueryPiece()
+		mqp.QuerySQL = &resetSQL
+		if ms.serverRespType != int(ErrHeader) {
+			ms.resetSessionState()
+		}
 
-		default:
+	default:
+		if len(commandName(command)) < 1 {
 			return
 		}
+
+		commandSQL := composeCommandSQL(command, ms.toUTF8(ms.cachedStmtBytes[1:]))
+		querySQLInBytes = hack.Slice(commandSQL)
+		mqp = ms.composeQueryPiece()
+		mqp.QuerySQL = &commandSQL
+	}
+
+	if isTrackedCommand(command) {
+		trxID := ms.trackTransaction(querySQLInBytes)
+		if mqp != nil {
+			mqp.TrxID = trxID
+		}
+	} else if mqp != nil && ms.trx != nil {
+		mqp.TrxID = ms.trx.id
 	}
 
-	if strictMode && mqp != nil && mqp.VisitUser == nil {
-		user, db, err := querySessionInfo(ms.serverPort, mqp.SessionID)
-		if err != nil {
-			log.Errorf("query user and db from mysql failed <-- %s", err.Error())
-		} else {
-			mqp.VisitUser = user
-			mqp.VisitDB = db
+	ms.trackSessionChange(command, querySQLInBytes)
+
+	if mqp != nil && ignoredCommands[command] {
+		mqp.Recovery()
+		return nil
+	}
+	if mqp != nil {
+		mqp.Command = commandName(command)
+	}
+
+	if mqp != nil && ms.stmtPayloadLen() > int64(len(ms.cachedStmtBytes)) {
+		sqlLength := ms.stmtPayloadLen() - 1
+		mqp.Truncated = true
+		mqp.SQLLength = &sqlLength
+	}
+
+	if mqp != nil && ms.response != nil {
+		mqp.Results = ms.response.results
+		if ms.response.localInfile {
+			infileBytes := ms.response.infileBytes
+			mqp.InfileBytes = &infileBytes
 		}
+		// cost time is till the last packet of response
+		mqp.CostTimeInMS = (ms.response.lastReadNano - ms.stmtBeginTimeNano) / millSecondUnit
 	}
 
 	mqp = filterQueryPieceBySQL(mqp, querySQLInBytes)
 	if mqp == nil {
 		return nil
 	}
+	ms.checkRisk(mqp, querySQLInBytes)
+	ms.setFingerprint(mqp, querySQLInBytes)
 
+	// fast query dropped is still counted in qps
 	communicator.ReceiveExecTime(ms.stmtBeginTimeNano)
+	if !keepSlowQuery(mqp) {
+		mqp.Recovery()
+		return nil
+	}
+	if extractTableNames {
+		ms.setTables(mqp, querySQLInBytes)
+	}
+	ms.redact(mqp)
 	return mqp
 }
 
+// sessionCapability return client capability of session,
+// use default capability if the handshake is not captured
+func (ms *MysqlSession) sessionCapability() uint32 {
+	if ms.capability == 0 {
+		return defaultCapability
+	}
+	return ms.capability
+}
+
+// setConnAttrs keep connection attributes and encode the ones in whitelist for query piece
+func (ms *MysqlSession) setConnAttrs(attrs map[string]string) {
+	ms.connAttrs = attrs
+	ms.exportConnAttrs = nil
+
+	exportAttrs := attrs
+	if connAttrWhitelist != nil {
+		exportAttrs = make(map[string]string, len(connAttrWhitelist))
+		for key, val := range attrs {
+			if connAttrWhitelist[key] {
+				exportAttrs[key] = val
+			}
+		}
+	}
+	if len(exportAttrs) < 1 {
+		return
+	}
+
+	content, err := json.Marshal(exportAttrs)
+	if err != nil {
+		log.Errorf("encode connection attributes failed <-- %s", err.Error())
+		return
+	}
+	ms.exportConnAttrs = content
+}
+
+// applyChangeUser switch session to user, db and charset of succeeded COM_CHANGE_USER
+func (ms *MysqlSession) applyChangeUser(changeUser *handshakeResponse41) {
+	ms.resetSessionState()
+	ms.visitUser = &changeUser.User
+	ms.visitDB = &changeUser.DBName
+	if changeUser.Collation > 0 {
+		ms.collation = changeUser.Collation
+		ms.charset = getCollationCharset(changeUser.Collation)
+	}
+	if changeUser.Attrs != nil {
+		ms.setConnAttrs(changeUser.Attrs)
+	}
+}
+
+// resetSessionState clear the state bound to the connection,
+// server do the same thing after COM_RESET_CONNECTION and COM_CHANGE_USER
+func (ms *MysqlSession) resetSessionState() {
+	ms.finishTransaction(TrxOutcomeReset)
+	ms.sessionVars = nil
+	ms.cachedPrepareStmt = make(map[int]*preparedStatement, 8)
+	ms.prepareRecoveries = nil
+}
+
+// getPreparedStatement get prepared statement from session cache,
+// if not found and recover prepare is set, query it from server asynchronously
+func (ms *MysqlSession) getPreparedStatement(stmtID int) (stmt *preparedStatement, ok bool) {
+	stmt, ok = ms.cachedPrepareStmt[stmtID]
+	if ok || !recoverPrepare {
+		return
+	}
+	return ms.recoverPreparedStatement(stmtID)
+}
+
+// fillExecuteParams decode params of COM_STMT_EXECUTE into query piece
+func (ms *MysqlSession) fillExecuteParams(mqp *model.PooledMysqlQueryPiece, stmt *preparedStatement) {
+	params, err := parseExecuteParams(stmt, ms.cachedStmtBytes[1:], ms.sessionCapability())
+	if err == errNoParamTypes {
+		// types of recovered statement are unknown until client bind params again
+		return
+	} else if err != nil {
+		log.Warningf("parse params of prepare statement failed <-- %s", err.Error())
+		return
+	}
+
+	for i, param := range params {
+		if strParam, ok := param.(string); ok {
+			params[i] = string(ms.toUTF8(hack.Slice(strParam)))
+		}
+	}
+	mqp.Params = params
+	if interpolatePrepareParams && params != nil {
+		interpolatedSQL := interpolateParams(stmt.sql, params)
+		mqp.InterpolatedSQL = &interpolatedSQL
+	}
+}
+
+// toUTF8 transcode text from session charset to utf8
+func (ms *MysqlSession) toUTF8(text []byte) []byte {
+	return convertToUTF8(ms.charset, text)
+}
+
 func filterQueryPieceBySQL(mqp *model.PooledMysqlQueryPiece, querySQL []byte) *model.PooledMysqlQueryPiece {
 	if mqp == nil || querySQL == nil {
 		return nil
+	}
 
-	} else if uselessSQLPattern.Match(querySQL) {
-		return nil
+	stmtType, readOnly := classifyStatement(querySQL)
+	mqp.StmtType = stmtType
+	if readOnly {
+		mqp.ReadOnly = &readOnlyTrue
+	} else {
+		mqp.ReadOnly = &readOnlyFalse
 	}
 
-	if ddlPatern.Match(querySQL) {
-		mqp.SetNeedSyncSend(true)
+	if !filterQueryPiece(mqp, querySQL) {
+		mqp.Recovery()
+		return nil
 	}
+	routeQueryPiece(mqp, querySQL)
 
 	return mqp
 }
@@ -337,7 +860,13 @@ func filterQueryPieceBySQL(mqp *model.PooledMysqlQueryPiece, querySQL []byte) *m
 func (ms *MysqlSession) composeQueryPiece() (mqp *model.PooledMysqlQueryPiece) {
 	clientIP := ms.clientIP
 	clientPort := ms.clientPort
-	return model.NewPooledMysqlQueryPiece(
+	mqp = model.NewPooledMysqlQueryPiece(
 		ms.connectionID, clientIP, ms.visitUser, ms.visitDB, ms.serverIP,
 		clientPort, ms.serverPort, communicator.GetMysqlCapturePacketRate(), ms.stmtBeginTimeNano)
+	mqp.ServerVersion = ms.serverVersion
+	mqp.ConnectionID = ms.serverThreadID
+	mqp.ConnAttrs = ms.exportConnAttrs
+	mqp.AuthPlugin = ms.authPlugin
+	mqp.SessionVars = ms.sessionVars
+	return
 }
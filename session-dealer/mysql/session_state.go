@@ -0,0 +1,312 @@
+package mysql
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+// Session variables tracked.
+const (
+	VarAutocommit         = "autocommit"
+	VarSQLMode            = "sql_mode"
+	VarTimeZone           = "time_zone"
+	VarTxIsolation        = "transaction_isolation"
+	VarTxIsolationOld     = "tx_isolation"
+	VarCharacterSetClient = "character_set_client"
+)
+
+// Session state change types in OK packet.
+const (
+	SessionTrackSystemVariables byte = iota
+	SessionTrackSchema
+)
+
+var (
+	useDBPattern = regexp.MustCompile("(?i)^\\s*use\\s+`?([^`\\s;]+)`?")
+	// SET TRANSACTION without SESSION only affect the next transaction
+	setIsolationPattern = regexp.MustCompile(
+		`(?i)^\s*set\s+(?:session|local)\s+transaction\s+isolation\s+level\s+` +
+			`(read\s+uncommitted|read\s+committed|repeatable\s+read|serializable)`)
+	blankPattern = regexp.MustCompile(`\s+`)
+)
+
+// setScopes is the scope prefix of variable in SET statement
+var setScopes = []struct {
+	prefix string
+	global bool
+}{
+	{"session ", false},
+	{"local ", false},
+	{"@@session.", false},
+	{"@@local.", false},
+	{"global ", true},
+	{"persist ", true},
+	{"persist_only ", true},
+	{"@@global.", true},
+	{"@@persist.", true},
+	{"@@persist_only.", true},
+	{"@@", false},
+}
+
+// trackSessionChange update session state after statement finished, the state is
+// reported by server in response or guessed from sql if response is not captured,
+// sql is skipped only if server returned ERR
+func (ms *MysqlSession) trackSessionChange(command byte, querySQL []byte) {
+	if ms.response == nil {
+		if command == ComQuery && ms.serverRespType != int(ErrHeader) {
+			ms.trackSessionSQL(querySQL)
+		}
+		return
+	}
+
+	if command == ComQuery && ms.response.lastErrorCode == 0 {
+		ms.trackSessionSQL(querySQL)
+	}
+	if ms.response.statusKnown {
+		ms.trackAutocommit(ms.response.status)
+	}
+	for _, state := range ms.response.sessionStates {
+		ms.trackSessionState(state)
+	}
+}
+
+// trackSessionSQL update session state by USE and SET statements executed successfully
+func (ms *MysqlSession) trackSessionSQL(querySQL []byte) {
+	if match := useDBPattern.FindSubmatch(querySQL); match != nil {
+		dbName := string(match[1])
+		ms.visitDB = &dbName
+		return
+	}
+
+	if match := setIsolationPattern.FindSubmatch(querySQL); match != nil {
+		ms.setSessionVar(VarTxIsolation, string(match[1]))
+		return
+	}
+
+	for _, assign := range parseSetAssignments(querySQL) {
+		if assign.known {
+			ms.setSessionVar(assign.name, assign.value)
+		} else {
+			// expression is evaluated by server, variable is unknown until server report it
+			ms.setSessionVar(assign.name, "")
+		}
+	}
+}
+
+// trackSessionState update session state by session state info in OK packet
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_basic_ok_packet.html
+func (ms *MysqlSession) trackSessionState(state []byte) {
+	defer func() {
+		// ignore the malformed rest
+		recover()
+	}()
+
+	offset := 0
+	for offset < len(state) {
+		stateType := state[offset]
+		var data string
+		data, offset = readLengthEncodedString(state, offset+1)
+		switch stateType {
+		case SessionTrackSystemVariables:
+			name, next := readLengthEncodedString([]byte(data), 0)
+			value, _ := readLengthEncodedString([]byte(data), next)
+			ms.setSessionVar(name, value)
+
+		case SessionTrackSchema:
+			dbName, _ := readLengthEncodedString([]byte(data), 0)
+			ms.visitDB = &dbName
+		}
+	}
+}
+
+// setSessionVar update tracked variable with a new snapshot, because the old one
+// may be referred by query pieces not sent yet, empty value means unknown
+func (ms *MysqlSession) setSessionVar(name, value string) {
+	name = strings.ToLower(name)
+	value = strings.Trim(value, "'\"")
+	if strings.EqualFold(value, "default") {
+		value = ""
+	}
+
+	vars := model.SessionVars{}
+	if ms.sessionVars != nil {
+		vars = *ms.sessionVars
+	}
+
+	switch name {
+	case VarAutocommit:
+		switch strings.ToUpper(value) {
+		case "1", "ON", "TRUE":
+			value = "ON"
+		case "0", "OFF", "FALSE":
+			value = "OFF"
+		default:
+			value = ""
+		}
+		vars.Autocommit = value
+
+	case VarSQLMode:
+		vars.SQLMode = strings.ToUpper(value)
+
+	case VarTimeZone:
+		vars.TimeZone = value
+
+	case VarTxIsolation, VarTxIsolationOld:
+		vars.TxIsolation = blankPattern.ReplaceAllString(strings.ToUpper(strings.TrimSpace(value)), "-")
+
+	case VarCharacterSetClient:
+		if charset := normalizeCharset(value); len(charset) > 0 {
+			ms.charset = charset
+		}
+		return
+
+	default:
+		return
+	}
+
+	switch {
+	case vars == (model.SessionVars{}):
+		ms.sessionVars = nil
+	case ms.sessionVars == nil || vars != *ms.sessionVars:
+		ms.sessionVars = &vars
+	}
+}
+
+// trackAutocommit update autocommit by server status flags
+func (ms *MysqlSession) trackAutocommit(status uint16) {
+	if status&ServerStatusAutocommit > 0 {
+		ms.setSessionVar(VarAutocommit, "ON")
+	} else {
+		ms.setSessionVar(VarAutocommit, "OFF")
+	}
+}
+
+// setAssignment is session variable assigned in SET statement, known is false if
+// value is an expression, like concat(@@sql_mode, ',X'), value is empty then
+type setAssignment struct {
+	name  string
+	value string
+	known bool
+}
+
+// parseSetAssignments get session variable assignments in SET statement
+func parseSetAssignments(querySQL []byte) (assigns []setAssignment) {
+	trimmed := bytes.TrimSpace(querySQL)
+	if len(trimmed) < 4 || !bytes.EqualFold(trimmed[:4], []byte("set ")) {
+		return
+	}
+	sql := string(trimmed)
+
+	pos := 4
+	for pos < len(sql) {
+		pos = skipSpaces(sql, pos)
+		global := false
+		lower := strings.ToLower(sql[pos:])
+		for _, scope := range setScopes {
+			if strings.HasPrefix(lower, scope.prefix) {
+				global = scope.global
+				pos = skipSpaces(sql, pos+len(scope.prefix))
+				break
+			}
+		}
+
+		nameBegin := pos
+		for pos < len(sql) && (isIdentChar(sql[pos]) || sql[pos] == '@') {
+			pos++
+		}
+		name := sql[nameBegin:pos]
+
+		pos = skipSpaces(sql, pos)
+		if strings.HasPrefix(sql[pos:], ":=") {
+			pos += 2
+		} else if strings.HasPrefix(sql[pos:], "=") {
+			pos++
+		} else {
+			return
+		}
+		pos = skipSpaces(sql, pos)
+
+		value, simple, next := readSetValue(sql, pos)
+		pos = next
+		if len(name) > 0 && name[0] != '@' && !global {
+			assign := setAssignment{name: name, known: simple}
+			if simple {
+				assign.value = value
+			}
+			assigns = append(assigns, assign)
+		}
+
+		pos = skipSpaces(sql, pos)
+		if pos >= len(sql) || sql[pos] != ',' {
+			return
+		}
+		pos++
+	}
+	return
+}
+
+// readSetValue read value expression until comma out of quotes and brackets,
+// simple means the value is a quoted string or a single word
+func readSetValue(sql string, pos int) (value string, simple bool, next int) {
+	end := pos
+	if pos < len(sql) && (sql[pos] == '\'' || sql[pos] == '"') {
+		quote := sql[pos]
+		for end++; end < len(sql) && sql[end] != quote; end++ {
+			if sql[end] == '\\' {
+				end++
+			}
+		}
+		end = minInt(end+1, len(sql))
+	} else {
+		for end < len(sql) && (isIdentChar(sql[end]) || sql[end] == '+' || sql[end] == '-' || sql[end] == ':') {
+			end++
+		}
+	}
+
+	value = sql[pos:end]
+	next = skipSpaces(sql, end)
+	simple = len(value) > 0 && (next >= len(sql) || sql[next] == ',' || sql[next] == ';')
+	if !simple {
+		next = skipExpression(sql, next)
+	}
+	return
+}
+
+// skipExpression move to the comma ends expression
+func skipExpression(sql string, pos int) int {
+	depth := 0
+	for ; pos < len(sql); pos++ {
+		switch sql[pos] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '\'', '"', '`':
+			quote := sql[pos]
+			for pos++; pos < len(sql) && sql[pos] != quote; pos++ {
+				if sql[pos] == '\\' {
+					pos++
+				}
+			}
+		case ',', ';':
+			if depth <= 0 {
+				return pos
+			}
+		}
+	}
+	return pos
+}
+
+func skipSpaces(sql string, pos int) int {
+	for pos < len(sql) && (sql[pos] == ' ' || sql[pos] == '\t' || sql[pos] == '\n' || sql[pos] == '\r') {
+		pos++
+	}
+	return pos
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '.' || c == '$' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
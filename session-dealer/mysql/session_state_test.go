@@ -0,0 +1,65 @@
+package mysql
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+func TestParseSetAssignments(t *testing.T) {
+	cases := []struct {
+		sql     string
+		assigns []setAssignment
+	}{
+		{"set autocommit=0", []setAssignment{{"autocommit", "0", true}}},
+		{"SET session a = 1, b='x'", []setAssignment{{"a", "1", true}, {"b", "'x'", true}}},
+		{"set @@session.time_zone = '+08:00'", []setAssignment{{"time_zone", "'+08:00'", true}}},
+		{"set local tx_isolation := 'READ-COMMITTED';", []setAssignment{{"tx_isolation", "'READ-COMMITTED'", true}}},
+		{"set sql_mode = concat(@@sql_mode, ',X')", []setAssignment{{"sql_mode", "", false}}},
+		{"set sql_mode = concat(@@sql_mode, ',X'), autocommit = 1",
+			[]setAssignment{{"sql_mode", "", false}, {"autocommit", "1", true}}},
+		{"set wait_timeout = 10 * 60", []setAssignment{{"wait_timeout", "", false}}},
+		{"set global max_connections = 100, autocommit = 1", []setAssignment{{"autocommit", "1", true}}},
+		{"set @a = 1, @@global.x = 2", nil},
+		{"set names utf8", nil},
+		{"select 1", nil},
+	}
+
+	for _, c := range cases {
+		if assigns := parseSetAssignments([]byte(c.sql)); !reflect.DeepEqual(assigns, c.assigns) {
+			t.Errorf("parse %q\n got: %+v\nwant: %+v", c.sql, assigns, c.assigns)
+		}
+	}
+}
+
+func TestTrackSessionChange(t *testing.T) {
+	ts := newTestSession()
+	ts.query("use db1", okPacket(0, ServerStatusAutocommit))
+	ts.query("set session time_zone = '+08:00', sql_mode = ''", okPacket(0, ServerStatusAutocommit))
+	ts.query("set time_zone = 'bad'", errPacket(1298))
+	// session state reported by server in OK packet
+	state := []byte{SessionTrackSchema, 4, 3, 'd', 'b', '2',
+		SessionTrackSystemVariables, 16, 8, 's', 'q', 'l', '_', 'm', 'o', 'd', 'e', 6, 'A', 'N', 'S', 'I', '_', 'Q'}
+	ok := append([]byte{OKHeader, 0, 0, 0x02, 0x40, 0, 0, 0}, byte(len(state)))
+	ts.query("call change_state()", append(ok, state...))
+	for ts.piece() != nil {
+	}
+
+	want := model.SessionVars{Autocommit: "ON", TimeZone: "+08:00", SQLMode: "ANSI_Q"}
+	if ts.visitDB == nil || *ts.visitDB != "db2" || ts.sessionVars == nil || *ts.sessionVars != want {
+		t.Errorf("got db %v vars %+v, want db2 %+v", ts.visitDB, ts.sessionVars, want)
+	}
+
+	// response is not captured, statement is tracked unless server returned ERR
+	ts.response = nil
+	ts.trackSessionChange(ComQuery, []byte("use db3"))
+	if *ts.visitDB != "db3" {
+		t.Errorf("use without response got db %s, want db3", *ts.visitDB)
+	}
+	ts.serverRespType = int(ErrHeader)
+	ts.trackSessionChange(ComQuery, []byte("use db4"))
+	if *ts.visitDB != "db3" {
+		t.Errorf("use failed got db %s, want db3", *ts.visitDB)
+	}
+}
@@ -0,0 +1,420 @@
+package mysql
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+var prepareTestEnv sync.Once
+
+// testSession feed mysql packets to session and collect the query pieces it sends
+type testSession struct {
+	*MysqlSession
+	recv chan model.QueryPiece
+	seq  int64
+}
+
+func newTestSession() (ts *testSession) {
+	prepareTestEnv.Do(func() {
+		MaxMySQLPacketLen = 128 * 1024
+		PrepareEnv()
+	})
+
+	key, ip := "10.0.0.1:1000", "10.0.0.1"
+	recv := make(chan model.QueryPiece, 8)
+	return &testSession{
+		MysqlSession: NewMysqlSession(&key, &ip, 1000, &ip, 3306, recv),
+		recv:         recv,
+		seq:          100,
+	}
+}
+
+// mysqlPackets compose mysql packets of payloads with sequence id from 1
+func mysqlPackets(payloads ...[]byte) (stream []byte) {
+	for i, payload := range payloads {
+		size := len(payload)
+		stream = append(stream, byte(size), byte(size>>8), byte(size>>16), byte(i+1))
+		stream = append(stream, payload...)
+	}
+	return
+}
+
+// client send command packet to server
+func (ts *testSession) client(payload ...byte) {
+	ts.clientPacket(0, payload)
+}
+
+// clientPacket send packet of sequence id to server
+func (ts *testSession) clientPacket(seqID byte, payload []byte) {
+	size := len(payload)
+	packet := append([]byte{byte(size), byte(size >> 8), byte(size >> 16), seqID}, payload...)
+	ts.ReceiveTCPPacket(model.NewTCPPacket(packet, ts.seq, true))
+	ts.seq += int64(len(packet))
+}
+
+// server send response packets to client
+func (ts *testSession) server(payloads ...[]byte) {
+	ts.ReceiveTCPPacket(model.NewTCPPacket(mysqlPackets(payloads...), ts.seq, false))
+}
+
+// query send COM_QUERY of sql and the response packets of server
+func (ts *testSession) query(querySQL string, payloads ...[]byte) {
+	ts.client(append([]byte{ComQuery}, querySQL...)...)
+	ts.server(payloads...)
+}
+
+// okPacket compose OK packet with affected rows and server status
+func okPacket(affectedRows byte, status uint16) []byte {
+	return []byte{OKHeader, affectedRows, 0, byte(status), byte(status >> 8), 0, 0}
+}
+
+// errPacket compose ERR packet with error code
+func errPacket(code uint16) []byte {
+	return append([]byte{ErrHeader, byte(code), byte(code >> 8), '#', 'H', 'Y', '0', '0', '0'}, "error"...)
+}
+
+// piece return the query piece session sent, nil if there is none
+func (ts *testSession) piece() *model.PooledMysqlQueryPiece {
+	select {
+	case qp := <-ts.recv:
+		return qp.(*model.PooledMysqlQueryPiece)
+	default:
+		return nil
+	}
+}
+
+// prepare send COM_STMT_PREPARE of statement 1 with params of field type
+func (ts *testSession) prepare(querySQL string, fieldTypes ...byte) {
+	ts.client(append([]byte{ComStmtPrepare}, querySQL...)...)
+	response := [][]byte{{OKHeader, 1, 0, 0, 0, 0, 0, byte(len(fieldTypes)), 0, 0, 0, 0}}
+	for _, fieldType := range fieldTypes {
+		response = append(response, columnDefinition(fieldType))
+	}
+	ts.server(append(response, []byte{EOFHeader, 0, 0, 0x02, 0})...)
+	ts.piece()
+}
+
+// columnDefinition compose Protocol::ColumnDefinition41 of field type
+func columnDefinition(fieldType byte) []byte {
+	return []byte{3, 'd', 'e', 'f', 0, 0, 0, 1, '?', 0,
+		0x0c, 0x3f, 0, 0, 0, 0, 0, fieldType, 0, 0, 0, 0, 0}
+}
+
+func TestStmtFetch(t *testing.T) {
+	ts := newTestSession()
+	ts.prepare("select * from t where id > ?", TypeLonglong)
+
+	// execute with read only cursor, server open cursor and return no rows
+	ts.client(ComStmtExecute, 1, 0, 0, 0, 1, 1, 0, 0, 0, 0, 1, TypeLonglong, 0, 5, 0, 0, 0, 0, 0, 0, 0)
+	ts.server([]byte{1}, columnDefinition(TypeLonglong), []byte{EOFHeader, 0, 0, 0x42, 0})
+	execute := ts.piece()
+	if execute == nil || execute.StmtID == nil || *execute.StmtID != 1 || execute.CursorBT != 0 {
+		t.Fatalf("execute should be sent with stmt id, got %+v", execute)
+	}
+
+	row := []byte{OKHeader, 0, 7, 0, 0, 0, 0, 0, 0, 0}
+	for i := 0; i < 2; i++ {
+		ts.client(ComStmtFetch, 1, 0, 0, 0, 100, 0, 0, 0)
+		ts.server(row, row, []byte{EOFHeader, 0, 0, 0x82, 0})
+		fetch := ts.piece()
+		if fetch == nil {
+			t.Fatalf("fetch %d should be sent", i)
+		}
+		if fetch.StmtID == nil || *fetch.StmtID != 1 || fetch.CursorBT != execute.EventTime ||
+			*fetch.QuerySQL != "select * from t where id > ?" {
+			t.Errorf("fetch %d should be joined to execute at %d, got stmt %v cursor %d sql %q",
+				i, execute.EventTime, fetch.StmtID, fetch.CursorBT, *fetch.QuerySQL)
+		}
+		if fetch.RequestedRows == nil || *fetch.RequestedRows != 100 ||
+			len(fetch.Results) != 1 || *fetch.Results[0].Rows != 2 {
+			t.Errorf("fetch %d got requested rows %v results %+v", i, fetch.RequestedRows, fetch.Results)
+		}
+	}
+
+	// cursor is closed by reset
+	ts.client(ComStmtReset, 1, 0, 0, 0)
+	ts.server([]byte{OKHeader, 0, 0, 0x02, 0, 0, 0})
+	ts.piece()
+	if stmt := ts.cachedPrepareStmt[1]; stmt.cursorBeginNano != 0 {
+		t.Errorf("cursor should be closed by reset")
+	}
+}
+
+func TestStmtSendLongData(t *testing.T) {
+	ts := newTestSession()
+	ts.prepare("insert into t values (?, ?)", TypeBlob, TypeLong)
+
+	// long data is accumulated without output
+	ts.client(append([]byte{ComStmtSendLongData, 1, 0, 0, 0, 0, 0}, "hello "...)...)
+	ts.client(append([]byte{ComStmtSendLongData, 1, 0, 0, 0, 0, 0}, "world"...)...)
+	if piece := ts.piece(); piece != nil {
+		t.Fatalf("long data should not be sent, got %s", *piece.QuerySQL)
+	}
+
+	ts.client(ComStmtExecute, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, TypeBlob, 0, TypeLong, 0, 7, 0, 0, 0)
+	ts.server([]byte{OKHeader, 1, 0, 0x02, 0, 0, 0})
+	execute := ts.piece()
+	if execute == nil || len(execute.Params) != 2 ||
+		execute.Params[0].(binaryParam).String() != "X'68656c6c6f20776f726c64'" || execute.Params[1] != int64(7) {
+		t.Fatalf("long data should be the param of execution, got %+v", execute)
+	}
+
+	// long data is only used by one execution
+	if stmt := ts.cachedPrepareStmt[1]; stmt.longData != nil {
+		t.Errorf("long data is not cleared after execution")
+	}
+}
+
+func TestChangeUser(t *testing.T) {
+	changeUser := append([]byte{ComChangeUser}, "alice\x00\x01\x09newdb\x00\x1c\x00mysql_native_password\x00"...)
+	authSwitch := append([]byte{EOFHeader}, "caching_sha2_password\x00abc"...)
+	okPacket := []byte{OKHeader, 0, 0, 0x02, 0, 0, 0}
+	errPacket := append([]byte{ErrHeader, 0x15, 0x04}, "#28000denied"...)
+
+	cases := []struct {
+		name     string
+		response [][]byte
+		user     string
+		db       string
+		charset  string
+	}{
+		{"ok", [][]byte{okPacket}, "alice", "newdb", CharsetGBK},
+		{"error", [][]byte{errPacket}, "bob", "olddb", ""},
+		{"auth switch then ok", [][]byte{authSwitch, okPacket}, "alice", "newdb", CharsetGBK},
+		{"auth switch then error", [][]byte{authSwitch, errPacket}, "bob", "olddb", ""},
+	}
+
+	for _, c := range cases {
+		ts := newTestSession()
+		user, db := "bob", "olddb"
+		ts.visitUser, ts.visitDB = &user, &db
+		ts.capability = ClientProtocol41 | ClientSecureConnection | ClientPluginAuth | ClientConnectWithDB
+		ts.prepare("select ?", TypeLong)
+
+		ts.client(changeUser...)
+		ts.server(c.response[0])
+		if len(c.response) > 1 {
+			// user is switched only after the final OK of auth exchange
+			if *ts.visitUser != "bob" || ts.phase != phaseAuth {
+				t.Errorf("%s: user switched to %s before auth finished", c.name, *ts.visitUser)
+			}
+			ts.clientPacket(2, []byte{1, 2, 3})
+			ts.server(c.response[1])
+		}
+
+		if *ts.visitUser != c.user || *ts.visitDB != c.db || ts.charset != c.charset {
+			t.Errorf("%s: got user %s db %s charset %s, want %s %s %s",
+				c.name, *ts.visitUser, *ts.visitDB, ts.charset, c.user, c.db, c.charset)
+		}
+		if _, ok := ts.cachedPrepareStmt[1]; ok != (c.user == "bob") {
+			t.Errorf("%s: prepared statements should be cleared only if user changed", c.name)
+		}
+		if piece := ts.piece(); piece == nil || *piece.QuerySQL != "change user alice" {
+			t.Errorf("%s: change user should be sent, got %+v", c.name, piece)
+		}
+	}
+}
+
+func TestResetConnection(t *testing.T) {
+	ts := newTestSession()
+	ts.prepare("select ?", TypeLong)
+	ts.client(append([]byte{ComQuery}, "set autocommit = 0"...)...)
+	ts.server([]byte{OKHeader, 0, 0, 0, 0, 0, 0})
+	ts.piece()
+	if ts.sessionVars == nil {
+		t.Fatalf("session variables should be tracked")
+	}
+
+	ts.client(ComResetConnection)
+	ts.server([]byte{OKHeader, 0, 0, 0x02, 0, 0, 0})
+	if piece := ts.piece(); piece == nil || *piece.QuerySQL != "reset connection" {
+		t.Errorf("reset connection should be sent, got %+v", piece)
+	}
+	if len(ts.cachedPrepareStmt) != 0 || ts.sessionVars != nil {
+		t.Errorf("got prepared statements %v session variables %+v after reset", ts.cachedPrepareStmt, ts.sessionVars)
+	}
+}
+
+// greetingPayload compose initial handshake of mysql 8.0 with connection id 5
+func greetingPayload() []byte {
+	payload := append([]byte{HandshakeProtocolVersion}, "8.0.30\x00"...)
+	// connection id and auth data part 1 with filler
+	payload = append(payload, 5, 0, 0, 0)
+	payload = append(payload, make([]byte, 9)...)
+	// capability, collation, status, capability upper and auth data length
+	payload = append(payload, 0xff, 0xff, 45, 2, 0, 0xff, 0xdf, 21)
+	payload = append(payload, make([]byte, 10+13)...)
+	return append(payload, "caching_sha2_password\x00"...)
+}
+
+func TestReadGreeting(t *testing.T) {
+	greeting := append([]byte{byte(len(greetingPayload())), 0, 0, 0}, greetingPayload()...)
+	cases := []struct {
+		name       string
+		clientData []byte
+		greeting   bool
+	}{
+		{"fresh connection", nil, true},
+		// server packet like greeting after client data, a row for example, must be ignored
+		{"after client data", append([]byte{ComQuery}, "select 1"...), false},
+	}
+
+	for _, c := range cases {
+		ts := newTestSession()
+		if c.clientData != nil {
+			ts.client(c.clientData...)
+		}
+		ts.ReceiveTCPPacket(model.NewTCPPacket(greeting, ts.seq, false))
+
+		if greetingRead := ts.phase == phaseHandshake && ts.serverVersion != nil; greetingRead != c.greeting {
+			t.Errorf("%s: got greeting read %v, want %v", c.name, greetingRead, c.greeting)
+		}
+		if c.greeting && (*ts.serverVersion != "8.0.30" || ts.serverThreadID != 5 || *ts.authPlugin != "caching_sha2_password") {
+			t.Errorf("%s: got server version %s connection id %d auth plugin %s",
+				c.name, *ts.serverVersion, ts.serverThreadID, *ts.authPlugin)
+		}
+		if ts.ExpectGreeting() != (c.clientData == nil) {
+			t.Errorf("%s: got expect greeting %v", c.name, ts.ExpectGreeting())
+		}
+	}
+}
+
+func TestSetConnAttrs(t *testing.T) {
+	attrs := map[string]string{"_client_name": "libmysql", "program_name": "order-service"}
+	cases := []struct {
+		keys     string
+		exported string
+	}{
+		{"*", `{"_client_name":"libmysql","program_name":"order-service"}`},
+		{"program_name, _os", `{"program_name":"order-service"}`},
+		{"_os", ""},
+	}
+
+	defer func(whitelist map[string]bool) {
+		connAttrWhitelist = whitelist
+	}(connAttrWhitelist)
+	for _, c := range cases {
+		connAttrWhitelist = parseConnAttrWhitelist(c.keys)
+		ms := &MysqlSession{}
+		ms.setConnAttrs(attrs)
+		if string(ms.exportConnAttrs) != c.exported || !reflect.DeepEqual(ms.connAttrs, attrs) {
+			t.Errorf("whitelist %q got exported attrs %s, want %s", c.keys, ms.exportConnAttrs, c.exported)
+		}
+	}
+}
+
+// handshakeResponse compose HandshakeResponse41 of user with caching_sha2_password
+func handshakeResponse(user, db string) []byte {
+	capability := ClientProtocol41 | ClientSecureConnection | ClientPluginAuth | ClientConnectWithDB
+	payload := []byte{byte(capability), byte(capability >> 8), byte(capability >> 16), byte(capability >> 24),
+		0, 0, 0, 1, 45}
+	payload = append(payload, make([]byte, 23)...)
+	payload = append(payload, user+"\x00\x02ab"+db+"\x00caching_sha2_password\x00"...)
+	return payload
+}
+
+func TestAuthExchange(t *testing.T) {
+	okPacket := []byte{OKHeader, 0, 0, 0x02, 0, 0, 0}
+	errPacket := append([]byte{ErrHeader, 0x15, 0x04}, "#28000denied"...)
+	fullAuth := []byte{AuthMoreDataHeader, 0x04}
+	authSwitch := append([]byte{AuthSwitchRequest}, "mysql_native_password\x00abc"...)
+
+	cases := []struct {
+		name     string
+		exchange [][]byte
+		plugin   string
+	}{
+		{"fast auth", [][]byte{{AuthMoreDataHeader, 0x03}, okPacket}, "caching_sha2_password"},
+		{"full auth", [][]byte{fullAuth, {AuthMoreDataHeader, '-', '-'}, okPacket}, "caching_sha2_password"},
+		{"auth switch", [][]byte{authSwitch, okPacket}, "mysql_native_password"},
+		{"auth failed", [][]byte{fullAuth, errPacket}, "caching_sha2_password"},
+	}
+
+	for _, c := range cases {
+		ts := newTestSession()
+		ts.ReceiveTCPPacket(model.NewTCPPacket(
+			append([]byte{byte(len(greetingPayload())), 0, 0, 0}, greetingPayload()...), ts.seq, false))
+		ts.clientPacket(1, handshakeResponse("app", "shop"))
+
+		for i, packet := range c.exchange {
+			ts.server(packet)
+			if i < len(c.exchange)-1 {
+				if ts.phase != phaseAuth {
+					t.Errorf("%s: auth exchange should go on after packet %d", c.name, i)
+				}
+				// auth data of client looks like COM_QUERY, must not be parsed as command
+				ts.clientPacket(byte(i*2+3), []byte{ComQuery, 's', 'e', 'c', 'r', 'e', 't'})
+			}
+		}
+		if ts.phase != phaseCommand || *ts.visitUser != "app" || *ts.authPlugin != c.plugin {
+			t.Errorf("%s: got phase %d user %s auth plugin %s", c.name, ts.phase, *ts.visitUser, *ts.authPlugin)
+		}
+		if piece := ts.piece(); piece != nil {
+			t.Errorf("%s: auth data should not be sent as query, got %s", c.name, *piece.QuerySQL)
+		}
+
+		ts.client(append([]byte{ComQuery}, "select 1"...)...)
+		ts.server(okPacket)
+		if piece := ts.piece(); piece == nil || *piece.QuerySQL != "select 1" || *piece.VisitDB != "shop" {
+			t.Errorf("%s: query after auth should be sent, got %+v", c.name, piece)
+		}
+	}
+}
+
+func TestLongStatement(t *testing.T) {
+	cases := []struct {
+		name      string
+		sqlLen    int
+		truncated bool
+	}{
+		{"short", 100, false},
+		{"longer than max packet length", MaxMySQLPacketLen + 100, true},
+		{"split into packets", MaxPayloadLen + 100, true},
+	}
+
+	for _, c := range cases {
+		ts := newTestSession()
+		querySQL := append([]byte("select '"), bytes.Repeat([]byte{'x'}, c.sqlLen-len("select ''"))...)
+		querySQL = append(querySQL, '\'')
+		payload := append([]byte{ComQuery}, querySQL...)
+
+		// packets longer than MaxPayloadLen are split, and sent in tcp segments
+		var stream []byte
+		for seqID := 0; ; seqID++ {
+			size := minInt(len(payload), MaxPayloadLen)
+			stream = append(stream, byte(size), byte(size>>8), byte(size>>16), byte(seqID))
+			stream = append(stream, payload[:size]...)
+			payload = payload[size:]
+			if size < MaxPayloadLen {
+				break
+			}
+		}
+		for len(stream) > 0 {
+			segment := stream[:minInt(len(stream), 1<<20)]
+			ts.ReceiveTCPPacket(model.NewTCPPacket(segment, ts.seq, true))
+			ts.seq += int64(len(segment))
+			stream = stream[len(segment):]
+		}
+		ts.server([]byte{OKHeader, 0, 0, 0x02, 0, 0, 0})
+
+		piece := ts.piece()
+		if piece == nil {
+			t.Errorf("%s: statement should be sent", c.name)
+			continue
+		}
+		if piece.Truncated != c.truncated {
+			t.Errorf("%s: got truncated %v, want %v", c.name, piece.Truncated, c.truncated)
+		}
+		if c.truncated && (piece.SQLLength == nil || *piece.SQLLength != int64(c.sqlLen) ||
+			*piece.QuerySQL != string(querySQL[:MaxMySQLPacketLen-2])) {
+			t.Errorf("%s: got sql length %v and sql of %d bytes", c.name, piece.SQLLength, len(*piece.QuerySQL))
+		}
+		if !c.truncated && *piece.QuerySQL != string(querySQL) {
+			t.Errorf("%s: got sql %q", c.name, *piece.QuerySQL)
+		}
+	}
+}
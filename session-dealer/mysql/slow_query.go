@@ -0,0 +1,45 @@
+package mysql
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zr-hebo/sniffer-agent/communicator"
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+var (
+	// fastQueryCounts is the count of fast queries output for each digest in fastQueryMinute
+	fastQueryCounts = make(map[string]int64)
+	fastQueryMinute int64
+	fastQueryLock   sync.Mutex
+)
+
+// keepSlowQuery check query piece with long query time, query faster than it is dropped unless
+// it is sent synchronously or among the first queries of its digest in current minute
+func keepSlowQuery(mqp *model.PooledMysqlQueryPiece) bool {
+	longQueryTimeInMS := communicator.GetLongQueryTimeInMS()
+	if longQueryTimeInMS <= 0 || mqp.CostTimeInMS >= longQueryTimeInMS || mqp.NeedSyncSend() {
+		return true
+	}
+
+	fastQueries := communicator.GetFastQueriesPerDigest()
+	if fastQueries <= 0 {
+		return false
+	}
+
+	minute := time.Now().Unix() / 60
+	fastQueryLock.Lock()
+	defer fastQueryLock.Unlock()
+
+	if minute != fastQueryMinute {
+		// digests of last minute are forgotten, so the map only holds digests seen in one minute
+		fastQueryCounts = make(map[string]int64)
+		fastQueryMinute = minute
+	}
+	if fastQueryCounts[mqp.Digest] >= fastQueries {
+		return false
+	}
+	fastQueryCounts[mqp.Digest]++
+	return true
+}
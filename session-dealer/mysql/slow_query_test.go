@@ -0,0 +1,58 @@
+package mysql
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zr-hebo/sniffer-agent/communicator"
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+func TestKeepSlowQuery(t *testing.T) {
+	defer communicator.SetConfig(communicator.LONG_QUERY_TIME, float64(0))
+	defer communicator.SetConfig(communicator.FAST_QUERIES_PER_DIGEST, float64(0))
+
+	newPiece := func(digest string, costMS int64, syncSend bool) *model.PooledMysqlQueryPiece {
+		mqp := &model.PooledMysqlQueryPiece{}
+		mqp.Digest = digest
+		mqp.CostTimeInMS = costMS
+		mqp.SetNeedSyncSend(syncSend)
+		return mqp
+	}
+
+	if !keepSlowQuery(newPiece("A", 0, false)) {
+		t.Errorf("all queries should be kept without long query time")
+	}
+
+	if err := communicator.SetConfig(communicator.LONG_QUERY_TIME, 0.5); err != nil {
+		t.Fatalf("set long query time failed <-- %s", err.Error())
+	}
+	cases := []struct {
+		name  string
+		piece *model.PooledMysqlQueryPiece
+		keep  bool
+	}{
+		{"slow", newPiece("A", 500, false), true},
+		{"fast", newPiece("A", 499, false), false},
+		{"fast sync", newPiece("A", 10, true), true},
+	}
+	for _, c := range cases {
+		if keep := keepSlowQuery(c.piece); keep != c.keep {
+			t.Errorf("%s query got keep %v, want %v", c.name, keep, c.keep)
+		}
+	}
+
+	// the first fast queries of every digest are kept
+	if err := communicator.SetConfig(communicator.FAST_QUERIES_PER_DIGEST, float64(2)); err != nil {
+		t.Fatalf("set fast queries per digest failed <-- %s", err.Error())
+	}
+	var kept []string
+	for _, digest := range []string{"B", "C", "B", "B", "C", "C", "D"} {
+		if keepSlowQuery(newPiece(digest, 1, false)) {
+			kept = append(kept, digest)
+		}
+	}
+	if want := []string{"B", "C", "B", "C", "D"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("got kept fast queries %v, want %v", kept, want)
+	}
+}
@@ -0,0 +1,125 @@
+package mysql
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+
+	"github.com/zr-hebo/sniffer-agent/communicator"
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+// Actions of sync rule.
+const (
+	SyncActionSync  = "sync"
+	SyncActionAsync = "async"
+)
+
+// SyncRulesConfig is the config name of sync rules which can be set at runtime
+const SyncRulesConfig = "sync_rules"
+
+// syncRuleSetting holds current []*SyncRule
+var syncRuleSetting atomic.Value
+
+// SyncRule decide whether query piece is sent synchronously, like to sync topic of kafka,
+// rules are checked in order and the first matched rule takes effect, query piece matches
+// no rule is sent asynchronously
+type SyncRule struct {
+	// hits is the count of query pieces matched since rule loaded,
+	// it is updated atomically, keep it first for alignment
+	hits int64
+
+	Name string `json:"name,omitempty"`
+	pieceCondition
+	// Action is sync or async, default is sync
+	Action string `json:"action,omitempty"`
+}
+
+// syncRuleView is sync rule read at runtime, rule is never changed after loaded,
+// only hits is loaded atomically
+type syncRuleView struct {
+	*SyncRule
+	Hits int64 `json:"hits"`
+}
+
+// parseSyncRules parse rules in json, value is json text or decoded json array
+func parseSyncRules(val interface{}) (rules []*SyncRule, err error) {
+	if err = decodeRules(val, &rules); err != nil {
+		return nil, fmt.Errorf("parse sync rules failed <-- %s", err.Error())
+	}
+
+	for idx, rule := range rules {
+		if rule == nil {
+			return nil, fmt.Errorf("sync rule %d is empty", idx)
+		}
+		rule.Action = strings.ToLower(rule.Action)
+		switch rule.Action {
+		case "":
+			rule.Action = SyncActionSync
+		case SyncActionSync, SyncActionAsync:
+		default:
+			return nil, fmt.Errorf("unknown action %s of sync rule %d", rule.Action, idx)
+		}
+		if err = rule.compile(); err != nil {
+			return nil, fmt.Errorf("sync rule %d <-- %s", idx, err.Error())
+		}
+	}
+	return
+}
+
+// loadSyncRules read rules from file, if file not set, statements of types
+// in --sync_stmt_types are sent synchronously
+func loadSyncRules(path, stmtTypeNames string) (rules []*SyncRule, err error) {
+	if len(path) < 1 {
+		if len(splitList(stmtTypeNames)) < 1 {
+			return nil, nil
+		}
+		return parseSyncRules([]*SyncRule{{Name: "sync_stmt_types", pieceCondition: pieceCondition{StmtType: stmtTypeNames}}})
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read sync rules file %s failed <-- %s", path, err.Error())
+	}
+	return parseSyncRules(content)
+}
+
+func currentSyncRules() []*SyncRule {
+	rules, _ := syncRuleSetting.Load().([]*SyncRule)
+	return rules
+}
+
+// registerSyncRulesConfig make sync rules readable and replaceable at runtime,
+// hits are reset when rules replaced
+func registerSyncRulesConfig() {
+	communicator.RegisterConfig(SyncRulesConfig, func() interface{} {
+		rules := currentSyncRules()
+		views := make([]syncRuleView, 0, len(rules))
+		for _, rule := range rules {
+			views = append(views, syncRuleView{SyncRule: rule, Hits: atomic.LoadInt64(&rule.hits)})
+		}
+		return views
+	}, func(val interface{}) (err error) {
+		rules, err := parseSyncRules(val)
+		if err == nil {
+			syncRuleSetting.Store(rules)
+		}
+		return
+	})
+}
+
+// routeQueryPiece mark query piece to be sent synchronously if the first matched sync rule says so
+func routeQueryPiece(mqp *model.PooledMysqlQueryPiece, querySQL []byte) {
+	for _, rule := range currentSyncRules() {
+		if !rule.match(mqp, querySQL) {
+			continue
+		}
+
+		atomic.AddInt64(&rule.hits, 1)
+		if rule.Action == SyncActionSync {
+			mqp.SetNeedSyncSend(true)
+		}
+		return
+	}
+}
@@ -0,0 +1,68 @@
+package mysql
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zr-hebo/sniffer-agent/communicator"
+)
+
+func TestLoadSyncRules(t *testing.T) {
+	rules, err := loadSyncRules("", " ")
+	if err != nil || rules != nil {
+		t.Errorf("no sync stmt types got %v %v, want no rule", rules, err)
+	}
+
+	rules, err = loadSyncRules("", "ddl, dcl")
+	if err != nil || len(rules) != 1 || rules[0].Action != SyncActionSync ||
+		!reflect.DeepEqual(rules[0].stmtTypes, []string{StmtTypeDDL, StmtTypeDCL}) {
+		t.Errorf("sync stmt types got %+v %v", rules, err)
+	}
+
+	if _, err = loadSyncRules("", "ddl,read"); err == nil || !strings.Contains(err.Error(), "unknown statement type read") {
+		t.Errorf("unknown sync stmt type got error %v", err)
+	}
+	if _, err = parseSyncRules(`[{"action":"later"}]`); err == nil || !strings.Contains(err.Error(), "unknown action later") {
+		t.Errorf("unknown action got error %v", err)
+	}
+}
+
+func TestSyncRulesConfig(t *testing.T) {
+	ts := newTestSession()
+	defer syncRuleSetting.Store(currentSyncRules())
+
+	err := communicator.SetConfig(SyncRulesConfig, `[
+		{"name":"audit_async","db":"audit","action":"async"},
+		{"name":"ddl","stmt_type":"ddl,dcl"}]`)
+	if err != nil {
+		t.Fatalf("set sync rules failed <-- %s", err.Error())
+	}
+
+	queries := []struct {
+		sql  string
+		sync bool
+	}{
+		{"create table t (id int)", true},
+		{"select 1", false},
+		{"grant select on db.* to u", true},
+		{"use audit", false},
+		{"create table t2 (id int)", false},
+	}
+	for _, query := range queries {
+		ts.query(query.sql, okPacket(0, ServerStatusAutocommit))
+		piece := ts.piece()
+		if piece == nil || piece.NeedSyncSend() != query.sync {
+			t.Errorf("%s got piece %+v, want sync %v", query.sql, piece, query.sync)
+		}
+	}
+
+	// rules are read with hits, default action is filled
+	content, err := json.Marshal(communicator.GetConfig(SyncRulesConfig))
+	want := `[{"name":"audit_async","db":"audit","action":"async","hits":1},` +
+		`{"name":"ddl","stmt_type":"ddl,dcl","action":"sync","hits":2}]`
+	if err != nil || string(content) != want {
+		t.Errorf("got sync rules %s %v\nwant: %s", content, err, want)
+	}
+}
@@ -0,0 +1,374 @@
+package mysql
+
+import (
+	"bytes"
+	"sync"
+
+	log "github.com/golang/glog"
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+// Access type of table in statement.
+const (
+	TableAccessRead  = "read"
+	TableAccessWrite = "write"
+)
+
+var (
+	// tableCache is the tables extracted from statements by digest, unqualified tables have empty db
+	tableCache     = make(map[string][]model.TableAccess)
+	tableCacheLock sync.Mutex
+)
+
+// aliasStopWords is the keywords may follow table reference, which is not alias
+var aliasStopWords = map[string]bool{
+	"where": true, "join": true, "inner": true, "cross": true, "left": true, "right": true,
+	"natural": true, "straight_join": true, "on": true, "using": true, "set": true, "group": true,
+	"order": true, "limit": true, "having": true, "union": true, "for": true, "lock": true,
+	"window": true, "partition": true, "use": true, "force": true, "ignore": true, "values": true,
+	"value": true, "select": true, "into": true, "as": true, "to": true, "like": true,
+	"from": true, "except": true, "intersect": true, "procedure": true, "full": true,
+	"outer": true, "default": true, "character": true, "charset": true, "engine": true,
+	"add": true, "drop": true, "modify": true, "change": true, "rename": true, "with": true,
+}
+
+// notTableWords is the keywords at position of table name, like explain select and from dual
+var notTableWords = map[string]bool{
+	"dual": true, "select": true, "insert": true, "update": true, "delete": true, "replace": true,
+	"with": true, "table": true, "values": true, "format": true, "analyze": true, "extended": true,
+	"partitions": true, "for": true,
+}
+
+// tableExtractor find tables referenced in statement by tokens, it recognizes tables after
+// FROM, JOIN, UPDATE, INTO, TABLE and DELETE, which covers most dml and ddl
+type tableExtractor struct {
+	tokenizer sqlTokenizer
+	statement string
+	// cteNames is the names defined in WITH clause, they are not tables
+	cteNames       [][]byte
+	indexOrTrigger bool
+	deleteTargets  bool
+	tables         []model.TableAccess
+}
+
+// extractTables return tables referenced in sql, db of unqualified table is empty
+func extractTables(sql []byte) (tables []model.TableAccess) {
+	extractor := &tableExtractor{}
+	extractor.tokenizer.reset(sql)
+	extractor.extract()
+	return extractor.tables
+}
+
+func (e *tableExtractor) extract() {
+	var wordBuffer [16]byte
+	depth := 0
+	expectCTE := false
+	for {
+		kind, token := e.tokenizer.next()
+		switch {
+		case kind == tokenEOF:
+			return
+		case kind == tokenPunct && token[0] == '(':
+			depth++
+		case kind == tokenPunct && token[0] == ')':
+			depth--
+		case kind == tokenPunct && token[0] == ',':
+			expectCTE = e.statement == "with" && depth == 0
+		}
+		if kind != tokenWord && kind != tokenQuotedIdent {
+			continue
+		}
+
+		word := string(lowerWord(wordBuffer[:0], token))
+		if e.statement == "with" && depth == 0 {
+			// names in WITH clause until the statement begins
+			switch {
+			case expectCTE:
+				e.cteNames = append(e.cteNames, unquoteIdent(token))
+				expectCTE = false
+			case word == "select" || word == "insert" || word == "update" || word == "delete" || word == "replace":
+				e.startStatement(word)
+			}
+			continue
+		}
+		if kind != tokenWord {
+			continue
+		}
+
+		if len(e.statement) < 1 {
+			e.startStatement(word)
+			if word == "with" {
+				expectCTE = true
+				if e.peekWord("recursive") {
+					e.tokenizer.next()
+				}
+			}
+			continue
+		}
+
+		switch word {
+		case "from":
+			access := TableAccessRead
+			if e.statement == "delete" && !e.deleteTargets {
+				// delete from t, tables after from are deleted
+				access = TableAccessWrite
+				e.deleteTargets = true
+			}
+			e.readTableList(access, true)
+
+		case "join", "straight_join":
+			e.readTableList(TableAccessRead, false)
+
+		case "using":
+			if e.statement == "delete" {
+				e.readTableList(TableAccessRead, true)
+			}
+
+		case "into":
+			if e.statement == "insert" || e.statement == "replace" || e.statement == "load" {
+				if e.peekWord("table") {
+					e.tokenizer.next()
+				}
+				e.readTableList(TableAccessWrite, false)
+			}
+
+		case "table", "view":
+			switch e.statement {
+			case "create", "alter", "drop", "rename":
+				e.readTableList(TableAccessWrite, true)
+			}
+
+		case "to":
+			// rename table a to b, c to d
+			if e.statement == "rename" {
+				e.readTableList(TableAccessWrite, false)
+				if e.peekPunct(',') {
+					e.tokenizer.next()
+					e.readTableList(TableAccessWrite, false)
+				}
+			}
+
+		case "like":
+			// create table t like s
+			if e.statement == "create" {
+				e.readTableList(TableAccessRead, false)
+			}
+
+		case "index", "trigger":
+			e.indexOrTrigger = e.statement == "create" || e.statement == "drop"
+
+		case "on":
+			// create index i on t, create trigger tr before insert on t
+			if e.indexOrTrigger {
+				e.readTableList(TableAccessWrite, false)
+				e.indexOrTrigger = false
+			}
+		}
+	}
+}
+
+// startStatement read tables follow the first word of statement
+func (e *tableExtractor) startStatement(word string) {
+	e.statement = word
+	switch word {
+	case "update":
+		for e.peekWord("low_priority") || e.peekWord("ignore") {
+			e.tokenizer.next()
+		}
+		e.readTableList(TableAccessWrite, true)
+
+	case "delete":
+		e.deleteTargets = e.readDeleteTargets()
+
+	case "truncate", "describe", "desc", "explain":
+		access := TableAccessRead
+		if word == "truncate" {
+			access = TableAccessWrite
+		}
+		if e.peekWord("table") {
+			e.tokenizer.next()
+		}
+		e.readTableList(access, false)
+	}
+}
+
+// readDeleteTargets read tables before FROM in multiple table delete, return true if any
+func (e *tableExtractor) readDeleteTargets() bool {
+	for e.peekWord("low_priority") || e.peekWord("quick") || e.peekWord("ignore") {
+		e.tokenizer.next()
+	}
+	if e.peekWord("from") {
+		return false
+	}
+	e.readTableList(TableAccessWrite, true)
+	return true
+}
+
+// readTableList read table names separated by comma if list is true, alias and
+// modifiers like IF EXISTS are skipped
+func (e *tableExtractor) readTableList(access string, list bool) {
+	for {
+		for e.peekWord("if") || e.peekWord("not") || e.peekWord("exists") || e.peekWord("temporary") ||
+			e.peekWord("low_priority") || e.peekWord("ignore") || e.peekWord("lateral") {
+			e.tokenizer.next()
+		}
+
+		db, table, ok := e.readName()
+		if !ok {
+			return
+		}
+		if access == TableAccessRead && e.peekPunct('(') {
+			// table function like json_table
+			return
+		}
+		e.addTable(db, table, access)
+		if !list {
+			return
+		}
+
+		e.skipAlias()
+		if !e.peekPunct(',') {
+			return
+		}
+		e.tokenizer.next()
+	}
+}
+
+// readName read table name which may be qualified by db
+func (e *tableExtractor) readName() (db, table []byte, ok bool) {
+	saved := e.tokenizer
+	kind, token := e.tokenizer.next()
+	if kind != tokenWord && kind != tokenQuotedIdent {
+		e.tokenizer = saved
+		return
+	}
+	var wordBuffer [16]byte
+	if kind == tokenWord && notTableWords[string(lowerWord(wordBuffer[:0], token))] {
+		e.tokenizer = saved
+		return
+	}
+	table = unquoteIdent(token)
+
+	if e.peekPunct('.') {
+		e.tokenizer.next()
+		kind, token = e.tokenizer.next()
+		if kind != tokenWord && kind != tokenQuotedIdent {
+			return nil, nil, false
+		}
+		db = table
+		table = unquoteIdent(token)
+	}
+	return db, table, true
+}
+
+// skipAlias skip alias and its AS after table name
+func (e *tableExtractor) skipAlias() {
+	var wordBuffer [16]byte
+	saved := e.tokenizer
+	kind, token := e.tokenizer.next()
+	if kind == tokenWord && string(lowerWord(wordBuffer[:0], token)) == "as" {
+		e.tokenizer.next()
+		return
+	}
+	if kind == tokenQuotedIdent || (kind == tokenWord && !aliasStopWords[string(lowerWord(wordBuffer[:0], token))]) {
+		return
+	}
+	e.tokenizer = saved
+}
+
+func (e *tableExtractor) addTable(db, table []byte, access string) {
+	if len(db) < 1 {
+		for _, name := range e.cteNames {
+			if bytes.Equal(name, table) {
+				return
+			}
+		}
+	}
+
+	for i := range e.tables {
+		if e.tables[i].DB == string(db) && e.tables[i].Table == string(table) {
+			if access == TableAccessWrite {
+				e.tables[i].Access = access
+			}
+			return
+		}
+	}
+	e.tables = append(e.tables, model.TableAccess{DB: string(db), Table: string(table), Access: access})
+}
+
+func (e *tableExtractor) peekWord(word string) bool {
+	saved := e.tokenizer
+	kind, token := e.tokenizer.next()
+	e.tokenizer = saved
+	return kind == tokenWord && bytes.EqualFold(token, []byte(word))
+}
+
+func (e *tableExtractor) peekPunct(punct byte) bool {
+	saved := e.tokenizer
+	kind, token := e.tokenizer.next()
+	e.tokenizer = saved
+	return kind == tokenPunct && token[0] == punct
+}
+
+// unquoteIdent remove backticks of identifier
+func unquoteIdent(token []byte) []byte {
+	if len(token) < 2 || token[0] != '`' {
+		return token
+	}
+	token = token[1:]
+	if token[len(token)-1] == '`' {
+		token = token[:len(token)-1]
+	}
+	return bytes.Replace(token, []byte("``"), []byte("`"), -1)
+}
+
+// setTables fill tables referenced by sql into query piece, tables are cached by digest,
+// unqualified tables are resolved by current db of session
+func (ms *MysqlSession) setTables(mqp *model.PooledMysqlQueryPiece, querySQL []byte) {
+	tableCacheLock.Lock()
+	tables, ok := tableCache[mqp.Digest]
+	tableCacheLock.Unlock()
+
+	if !ok {
+		tables = ms.parseTables(querySQL)
+		tableCacheLock.Lock()
+		if len(tableCache) >= tableCacheSize {
+			// evict one arbitrary digest
+			for digest := range tableCache {
+				delete(tableCache, digest)
+				break
+			}
+		}
+		tableCache[mqp.Digest] = tables
+		tableCacheLock.Unlock()
+	}
+
+	if len(tables) < 1 {
+		return
+	}
+
+	// cached tables is shared, copy it when resolving db
+	resolved := tables
+	for i := range tables {
+		if len(tables[i].DB) > 0 || ms.visitDB == nil {
+			continue
+		}
+		if &resolved[0] == &tables[0] {
+			resolved = append([]model.TableAccess(nil), tables...)
+		}
+		resolved[i].DB = *ms.visitDB
+	}
+	mqp.Tables = resolved
+}
+
+// parseTables extract tables from sql, failure is logged and no table returned
+func (ms *MysqlSession) parseTables(querySQL []byte) (tables []model.TableAccess) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Warningf("extract tables from sql in session %s failed <-- %v", *ms.connectionID, r)
+			tables = nil
+		}
+	}()
+
+	return extractTables(querySQL)
+}
@@ -0,0 +1,88 @@
+package mysql
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+// describeTables format tables like db.t:write, unqualified table has no db
+func describeTables(tables []model.TableAccess) string {
+	var described []string
+	for _, table := range tables {
+		name := table.Table
+		if len(table.DB) > 0 {
+			name = table.DB + "." + name
+		}
+		described = append(described, name+":"+table.Access)
+	}
+	return strings.Join(described, " ")
+}
+
+func TestExtractTables(t *testing.T) {
+	cases := []struct {
+		sql    string
+		tables string
+	}{
+		{"select * from t1 a, db2.t2 as b where a.id = b.id", "t1:read db2.t2:read"},
+		{"SELECT * FROM `db 1`.`t``1` JOIN t2 USING (id) LEFT JOIN t3 ON t3.id = t2.id", "db 1.t`1:read t2:read t3:read"},
+		{"select * from (select id from t1) s join json_table(@j, '$[*]' columns (id int path '$')) j", "t1:read"},
+		{"select 1 from dual", ""},
+		{"insert into t1 (id) select id from t2", "t1:write t2:read"},
+		{"insert ignore into db.t1 values (1)", "db.t1:write"},
+		{"replace into t1 set id = 1", "t1:write"},
+		{"load data infile 'a.csv' into table t1", "t1:write"},
+		{"update low_priority t1 join t2 on t1.id = t2.id set t1.a = t2.a", "t1:write t2:read"},
+		{"update t1 set a = 1 where id in (select id from t1)", "t1:write"},
+		{"delete from t1 where id = 1", "t1:write"},
+		{"delete t1, t2 from t1 join t2 join t3", "t1:write t2:write t3:read"},
+		{"delete quick from t1 using t1 join t2", "t1:write t2:read"},
+		{"with recursive c (n) as (select 1 union select n + 1 from c) select * from c join t1", "t1:read"},
+		{"with a as (select * from t1), b as (select * from a) delete from t2 where id in (select id from b)",
+			"t1:read t2:write"},
+		{"create table if not exists t1 like t2", "t1:write t2:read"},
+		{"create temporary table t1 (id int)", "t1:write"},
+		{"alter table db.t1 add column c int", "db.t1:write"},
+		{"drop table if exists t1, t2", "t1:write t2:write"},
+		{"truncate table t1", "t1:write"},
+		{"rename table t1 to t2, t3 to t4", "t1:write t2:write t3:write t4:write"},
+		{"create index i on t1 (c)", "t1:write"},
+		{"create trigger tr before insert on t1 for each row set new.c = 1", "t1:write"},
+		{"explain select * from t1", "t1:read"},
+		{"desc t1", "t1:read"},
+		{"begin", ""},
+	}
+
+	for _, c := range cases {
+		if tables := describeTables(extractTables([]byte(c.sql))); tables != c.tables {
+			t.Errorf("extract %q\n got: %s\nwant: %s", c.sql, tables, c.tables)
+		}
+	}
+}
+
+func TestSetTables(t *testing.T) {
+	defer func(extract bool) {
+		extractTableNames = extract
+	}(extractTableNames)
+	extractTableNames = true
+
+	ts := newTestSession()
+	ts.query("select * from t1 join db2.t2", okPacket(0, ServerStatusAutocommit))
+	piece := ts.piece()
+	if piece == nil || describeTables(piece.Tables) != "t1:read db2.t2:read" {
+		t.Fatalf("tables without current db got %+v", piece)
+	}
+
+	// cached tables of the same digest are resolved by current db, and the cache is not changed
+	ts.query("use db1", okPacket(0, ServerStatusAutocommit))
+	ts.piece()
+	ts.query("select * from t1 join db2.t2", okPacket(0, ServerStatusAutocommit))
+	piece = ts.piece()
+	if piece == nil || describeTables(piece.Tables) != "db1.t1:read db2.t2:read" {
+		t.Errorf("tables with current db got %+v", piece)
+	}
+	if tables := describeTables(tableCache[piece.Digest]); tables != "t1:read db2.t2:read" {
+		t.Errorf("cached tables got %s", tables)
+	}
+}
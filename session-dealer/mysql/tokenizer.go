@@ -0,0 +1,256 @@
+package mysql
+
+import (
+	"bytes"
+)
+
+// Token kinds of sql tokenizer.
+const (
+	tokenEOF = iota
+	// tokenWord is keyword or identifier not quoted
+	tokenWord
+	tokenQuotedIdent
+	tokenString
+	tokenNumber
+	// tokenPlaceholder is ? in prepared statement
+	tokenPlaceholder
+	// tokenVariable is user variable or system variable, like @a and @@session.sql_mode
+	tokenVariable
+	// tokenPunct is one of ( ) , ; . and @ in account name
+	tokenPunct
+	tokenOperator
+)
+
+// multiCharOperators is operators longer than one byte, longer ones first
+var multiCharOperators = [][]byte{
+	[]byte("<=>"), []byte("->>"), []byte("<="), []byte(">="), []byte("<>"), []byte("!="),
+	[]byte(":="), []byte("||"), []byte("&&"), []byte("<<"), []byte(">>"), []byte("->"),
+}
+
+// sqlTokenizer split sql into tokens by MySQL lexical rules, comments are skipped,
+// tokens are slices of sql so no memory is allocated
+type sqlTokenizer struct {
+	sql []byte
+	pos int
+	// spaceBefore is true if there is blank or comment before the last token
+	spaceBefore bool
+	lastKind    int
+	lastToken   []byte
+}
+
+func newSQLTokenizer(sql []byte) *sqlTokenizer {
+	return &sqlTokenizer{sql: sql}
+}
+
+func (t *sqlTokenizer) reset(sql []byte) {
+	t.sql = sql
+	t.pos = 0
+	t.spaceBefore = false
+	t.lastKind = tokenEOF
+	t.lastToken = nil
+}
+
+// next return the next token, kind is tokenEOF at the end of sql
+func (t *sqlTokenizer) next() (kind int, token []byte) {
+	t.spaceBefore = t.skipBlankAndComment()
+	if t.pos >= len(t.sql) {
+		return tokenEOF, nil
+	}
+
+	begin := t.pos
+	c := t.sql[t.pos]
+	switch {
+	case c == '\'' || c == '"':
+		kind = tokenString
+		t.skipQuoted(c)
+
+	case c == '`':
+		kind = tokenQuotedIdent
+		t.skipQuoted(c)
+
+	case (c == 'x' || c == 'X' || c == 'b' || c == 'B' || c == 'n' || c == 'N') &&
+		t.pos+1 < len(t.sql) && t.sql[t.pos+1] == '\'':
+		// hex, bit and national string like x'0f'
+		kind = tokenString
+		t.pos++
+		t.skipQuoted('\'')
+
+	case isDigit(c) || (c == '.' && t.pos+1 < len(t.sql) && isDigit(t.sql[t.pos+1]) && !t.afterName()):
+		kind = t.scanNumber()
+
+	case (c == '-' || c == '+') && t.pos+1 < len(t.sql) && t.signAllowed() &&
+		(isDigit(t.sql[t.pos+1]) || (t.sql[t.pos+1] == '.' && t.pos+2 < len(t.sql) && isDigit(t.sql[t.pos+2]))):
+		// sign of number, not binary operator
+		t.pos++
+		kind = t.scanNumber()
+
+	case isWordChar(c):
+		kind = tokenWord
+		for t.pos < len(t.sql) && isWordChar(t.sql[t.pos]) {
+			t.pos++
+		}
+
+	case c == '@' && !t.spaceBefore &&
+		(t.lastKind == tokenString || t.lastKind == tokenQuotedIdent || t.lastKind == tokenWord):
+		// @ between user and host of account, like 'u'@'%', host is a string or word
+		kind = tokenPunct
+		t.pos++
+
+	case c == '@':
+		kind = tokenVariable
+		for t.pos < len(t.sql) && t.sql[t.pos] == '@' {
+			t.pos++
+		}
+		if t.pos < len(t.sql) && (t.sql[t.pos] == '\'' || t.sql[t.pos] == '"' || t.sql[t.pos] == '`') {
+			t.skipQuoted(t.sql[t.pos])
+		}
+		for t.pos < len(t.sql) && (isWordChar(t.sql[t.pos]) || t.sql[t.pos] == '.') {
+			t.pos++
+		}
+
+	case c == '?':
+		kind = tokenPlaceholder
+		t.pos++
+
+	case c == '(' || c == ')' || c == ',' || c == ';' || c == '.':
+		kind = tokenPunct
+		t.pos++
+
+	default:
+		kind = tokenOperator
+		t.pos++
+		for _, operator := range multiCharOperators {
+			if bytes.HasPrefix(t.sql[begin:], operator) {
+				t.pos = begin + len(operator)
+				break
+			}
+		}
+	}
+
+	token = t.sql[begin:t.pos]
+	t.lastKind = kind
+	t.lastToken = token
+	return
+}
+
+// skipBlankAndComment move over blanks and comments, return true if anything is skipped
+func (t *sqlTokenizer) skipBlankAndComment() (skipped bool) {
+	for t.pos < len(t.sql) {
+		c := t.sql[t.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			t.pos++
+
+		case c == '#' || (c == '-' && t.commentBegins()):
+			for t.pos < len(t.sql) && t.sql[t.pos] != '\n' {
+				t.pos++
+			}
+
+		case c == '/' && t.commentBegins():
+			t.pos += 2
+			for t.pos < len(t.sql) && !(t.sql[t.pos] == '*' && t.pos+1 < len(t.sql) && t.sql[t.pos+1] == '/') {
+				t.pos++
+			}
+			t.pos = minInt(t.pos+2, len(t.sql))
+
+		default:
+			return
+		}
+		skipped = true
+	}
+	return
+}
+
+// commentBegins check if comment begins at current position, -- must be followed by blank
+func (t *sqlTokenizer) commentBegins() bool {
+	rest := t.sql[t.pos:]
+	if len(rest) < 2 {
+		return false
+	}
+	if rest[0] == '/' && rest[1] == '*' {
+		return true
+	}
+	if rest[0] == '-' && rest[1] == '-' {
+		return len(rest) == 2 || rest[2] == ' ' || rest[2] == '\t' || rest[2] == '\n' || rest[2] == '\r'
+	}
+	return false
+}
+
+// skipQuoted move over quoted text, quote is escaped by backslash or doubled quote,
+// backslash is not escape character in identifier
+func (t *sqlTokenizer) skipQuoted(quote byte) {
+	for t.pos++; t.pos < len(t.sql); t.pos++ {
+		c := t.sql[t.pos]
+		if c == '\\' && quote != '`' {
+			t.pos++
+		} else if c == quote {
+			if t.pos+1 < len(t.sql) && t.sql[t.pos+1] == quote {
+				t.pos++
+				continue
+			}
+			t.pos++
+			return
+		}
+	}
+	t.pos = len(t.sql)
+}
+
+// scanNumber move over number like 12, 1.5e-3 and 0x0f, identifier begins with digits is a word
+func (t *sqlTokenizer) scanNumber() int {
+	if t.sql[t.pos] == '0' && t.pos+1 < len(t.sql) && (t.sql[t.pos+1] == 'x' || t.sql[t.pos+1] == 'b') {
+		t.pos += 2
+		for t.pos < len(t.sql) && isWordChar(t.sql[t.pos]) {
+			t.pos++
+		}
+		return tokenNumber
+	}
+
+	for t.pos < len(t.sql) && (isDigit(t.sql[t.pos]) || t.sql[t.pos] == '.') {
+		t.pos++
+	}
+	if t.pos < len(t.sql) && (t.sql[t.pos] == 'e' || t.sql[t.pos] == 'E') {
+		exp := t.pos + 1
+		if exp < len(t.sql) && (t.sql[exp] == '-' || t.sql[exp] == '+') {
+			exp++
+		}
+		if exp < len(t.sql) && isDigit(t.sql[exp]) {
+			t.pos = exp
+			for t.pos < len(t.sql) && isDigit(t.sql[t.pos]) {
+				t.pos++
+			}
+		}
+	}
+
+	if t.pos < len(t.sql) && isWordChar(t.sql[t.pos]) {
+		for t.pos < len(t.sql) && isWordChar(t.sql[t.pos]) {
+			t.pos++
+		}
+		return tokenWord
+	}
+	return tokenNumber
+}
+
+// signAllowed check if + or - at current position can be sign of number
+func (t *sqlTokenizer) signAllowed() bool {
+	switch t.lastKind {
+	case tokenEOF, tokenOperator:
+		return true
+	case tokenPunct:
+		return t.lastToken[0] == '(' || t.lastToken[0] == ','
+	}
+	return false
+}
+
+// afterName check if . at current position is qualifier separator like t.1col
+func (t *sqlTokenizer) afterName() bool {
+	return !t.spaceBefore && (t.lastKind == tokenWord || t.lastKind == tokenQuotedIdent)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isWordChar check if c can be in identifier not quoted, bytes of multi-byte utf8 character included
+func isWordChar(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
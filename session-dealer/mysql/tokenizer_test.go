@@ -0,0 +1,65 @@
+package mysql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var tokenKindNames = map[int]string{
+	tokenWord:        "word",
+	tokenQuotedIdent: "ident",
+	tokenString:      "string",
+	tokenNumber:      "number",
+	tokenPlaceholder: "placeholder",
+	tokenVariable:    "variable",
+	tokenPunct:       "punct",
+	tokenOperator:    "operator",
+}
+
+// tokenize return tokens of sql as kind:text joined by blank
+func tokenize(sql string) string {
+	tokenizer := newSQLTokenizer([]byte(sql))
+	var tokens []string
+	for {
+		kind, token := tokenizer.next()
+		if kind == tokenEOF {
+			return strings.Join(tokens, " ")
+		}
+		tokens = append(tokens, fmt.Sprintf("%s:%s", tokenKindNames[kind], token))
+	}
+}
+
+func TestSQLTokenizer(t *testing.T) {
+	cases := []struct {
+		sql    string
+		tokens string
+	}{
+		{"", ""},
+		{"  /* only comment */ ", ""},
+		{"select 1", "word:select number:1"},
+		{"SELECT a,b FROM t", "word:SELECT word:a punct:, word:b word:FROM word:t"},
+		{"select 'it''s', \"a\\\"b\"", `word:select string:'it''s' punct:, string:"a\"b"`},
+		{"select `a``b` from `db`.`t`", "word:select ident:`a``b` word:from ident:`db` punct:. ident:`t`"},
+		{"select x'0f', b'01', N'abc'", "word:select string:x'0f' punct:, string:b'01' punct:, string:N'abc'"},
+		{"select 1.5e-3, .5, 0x1F", "word:select number:1.5e-3 punct:, number:.5 punct:, number:0x1F"},
+		{"select t.5a from t", "word:select word:t punct:. word:5a word:from word:t"},
+		{"where a=-1 and b - 1", "word:where word:a operator:= number:-1 word:and word:b operator:- number:1"},
+		{"where a<=>b and c!=d and e<>f", "word:where word:a operator:<=> word:b word:and word:c operator:!= word:d word:and word:e operator:<> word:f"},
+		{"select ? from t where id in (?, ?)", "word:select placeholder:? word:from word:t word:where word:id word:in punct:( placeholder:? punct:, placeholder:? punct:)"},
+		{"set @a := @@session.sql_mode", "word:set variable:@a operator::= variable:@@session.sql_mode"},
+		{"select 1 -- comment\n, 2 # other\n", "word:select number:1 punct:, number:2"},
+		{"select /*! STRAIGHT_JOIN */ 1", "word:select number:1"},
+		{"create user 'u'@'%'", "word:create word:user string:'u' punct:@ string:'%'"},
+		{"create user u@localhost", "word:create word:user word:u punct:@ word:localhost"},
+		{"select 'x' @b", "word:select string:'x' variable:@b"},
+		{"select 1;select 2", "word:select number:1 punct:; word:select number:2"},
+		{"select 'not closed", "word:select string:'not closed"},
+	}
+
+	for _, c := range cases {
+		if tokens := tokenize(c.sql); tokens != c.tokens {
+			t.Errorf("tokenize %q\n got: %s\nwant: %s", c.sql, tokens, c.tokens)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package mysql
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+// Transaction outcome information.
+const (
+	TrxOutcomeCommit         = "commit"
+	TrxOutcomeRollback       = "rollback"
+	TrxOutcomeImplicitCommit = "implicit_commit"
+	// TrxOutcomeDeadlock means transaction is rolled back by server for deadlock
+	TrxOutcomeDeadlock = "deadlock"
+	// TrxOutcomeReset means transaction is rolled back by change user or reset connection
+	TrxOutcomeReset = "reset"
+	// TrxOutcomeDisconnect means connection closed in transaction, server roll it back
+	TrxOutcomeDisconnect = "disconnect"
+)
+
+// ErLockDeadlock is the error code of deadlock, the whole transaction is rolled back
+const ErLockDeadlock = 1213
+
+// kind of transaction control statement
+const (
+	trxStmtNone = iota
+	trxStmtBegin
+	trxStmtCommit
+	trxStmtRollback
+)
+
+var (
+	trxBeginPattern  = regexp.MustCompile(`(?i)^\s*(begin|start\s+transaction)\b`)
+	trxCommitPattern = regexp.MustCompile(`(?i)^\s*commit\b`)
+	// rollback to savepoint does not end transaction
+	trxRollbackPattern = regexp.MustCompile(`(?i)^\s*rollback(\s+work)?\s*(;|$|and\b|release\b|no\b)`)
+)
+
+// transaction is statements executed between server status IN_TRANS set and cleared
+type transaction struct {
+	id         int64
+	beginNano  int64
+	endNano    int64
+	idleNano   int64
+	statements int
+}
+
+func classifyTrxStatement(querySQL []byte) int {
+	switch {
+	case trxBeginPattern.Match(querySQL):
+		return trxStmtBegin
+	case trxCommitPattern.Match(querySQL):
+		return trxStmtCommit
+	case trxRollbackPattern.Match(querySQL):
+		return trxStmtRollback
+	default:
+		return trxStmtNone
+	}
+}
+
+// trackTransaction update transaction of session after statement finished, use server status in response
+// or guess from sql if response is not captured, return transaction id of statement, 0 means not in transaction
+func (ms *MysqlSession) trackTransaction(querySQL []byte) (trxID int64) {
+	trxStmt := classifyTrxStatement(querySQL)
+	endNano := time.Now().UnixNano()
+	errCode := 0
+	inTrans := (ms.trx != nil || trxStmt == trxStmtBegin) &&
+		trxStmt != trxStmtCommit && trxStmt != trxStmtRollback
+	if ms.response != nil {
+		endNano = ms.response.lastReadNano
+		errCode = ms.response.lastErrorCode
+		if ms.response.statusKnown {
+			inTrans = ms.response.status&ServerStatusInTrans > 0
+		} else if errCode == ErLockDeadlock {
+			inTrans = false
+		} else if errCode > 0 {
+			inTrans = ms.trx != nil
+		}
+	}
+
+	// begin in transaction commit the former one implicitly
+	if ms.trx != nil && trxStmt == trxStmtBegin && errCode == 0 {
+		ms.finishTransaction(TrxOutcomeImplicitCommit)
+	}
+
+	if ms.trx == nil && !inTrans {
+		return
+	}
+
+	if ms.trx == nil {
+		ms.trxSeq++
+		ms.trx = &transaction{id: ms.trxSeq, beginNano: ms.stmtBeginTimeNano}
+	}
+	trx := ms.trx
+	if trx.endNano > 0 {
+		trx.idleNano += ms.stmtBeginTimeNano - trx.endNano
+	}
+	trx.statements++
+	trx.endNano = endNano
+	trxID = trx.id
+
+	if !inTrans {
+		switch {
+		case errCode == ErLockDeadlock:
+			ms.finishTransaction(TrxOutcomeDeadlock)
+		case trxStmt == trxStmtCommit:
+			ms.finishTransaction(TrxOutcomeCommit)
+		case trxStmt == trxStmtRollback:
+			ms.finishTransaction(TrxOutcomeRollback)
+		default:
+			ms.finishTransaction(TrxOutcomeImplicitCommit)
+		}
+	}
+	return
+}
+
+// finishTransaction generate summary of the transaction in session, its begin time is
+// the begin of first statement and cost time is till the end of last statement.
+// Summary is sent after the statement ending transaction, and is never filtered
+func (ms *MysqlSession) finishTransaction(outcome string) {
+	trx := ms.trx
+	if trx == nil {
+		return
+	}
+	ms.trx = nil
+	ms.sendTrxSummary()
+
+	stmtBeginTimeNano := ms.stmtBeginTimeNano
+	ms.stmtBeginTimeNano = trx.beginNano
+	mqp := ms.composeQueryPiece()
+	ms.stmtBeginTimeNano = stmtBeginTimeNano
+
+	if trx.endNano > trx.beginNano {
+		mqp.CostTimeInMS = (trx.endNano - trx.beginNano) / millSecondUnit
+	}
+	trxSQL := "transaction"
+	mqp.QuerySQL = &trxSQL
+	mqp.TrxID = trx.id
+	mqp.Transaction = &model.TransactionInfo{
+		Statements: trx.statements,
+		IdleMS:     trx.idleNano / millSecondUnit,
+		Outcome:    outcome,
+	}
+	ms.trxSummary = mqp
+}
+
+// sendTrxSummary send summary of finished transaction if any
+func (ms *MysqlSession) sendTrxSummary() {
+	if ms.trxSummary != nil {
+		ms.queryPieceReceiver <- ms.trxSummary
+		ms.trxSummary = nil
+	}
+}
@@ -0,0 +1,102 @@
+package mysql
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zr-hebo/sniffer-agent/model"
+)
+
+func TestClassifyTrxStatement(t *testing.T) {
+	cases := []struct {
+		sql     string
+		trxStmt int
+	}{
+		{"BEGIN", trxStmtBegin},
+		{"  begin work", trxStmtBegin},
+		{"start transaction read only", trxStmtBegin},
+		{"commit", trxStmtCommit},
+		{"COMMIT WORK AND NO CHAIN", trxStmtCommit},
+		{"rollback", trxStmtRollback},
+		{"rollback work;", trxStmtRollback},
+		{"rollback and chain", trxStmtRollback},
+		{"rollback to savepoint sp1", trxStmtNone},
+		{"beginning", trxStmtNone},
+		{"select 'commit'", trxStmtNone},
+	}
+
+	for _, c := range cases {
+		if trxStmt := classifyTrxStatement([]byte(c.sql)); trxStmt != c.trxStmt {
+			t.Errorf("classify %q got %d, want %d", c.sql, trxStmt, c.trxStmt)
+		}
+	}
+}
+
+// trxPieces return pieces of statements and the transaction summary session sent
+func (ts *testSession) trxPieces() (stmtTrxIDs []int64, summary *model.PooledMysqlQueryPiece) {
+	for piece := ts.piece(); piece != nil; piece = ts.piece() {
+		if piece.Transaction != nil {
+			summary = piece
+			continue
+		}
+		stmtTrxIDs = append(stmtTrxIDs, piece.TrxID)
+	}
+	return
+}
+
+func TestTransaction(t *testing.T) {
+	inTrans := ServerStatusInTrans | ServerStatusAutocommit
+	cases := []struct {
+		name       string
+		statements []string
+		responses  [][]byte
+		stmtTrxIDs []int64
+		outcome    string
+		trxStmts   int
+	}{
+		{"commit", []string{"begin", "insert into t values (1)", "commit"},
+			[][]byte{okPacket(0, inTrans), okPacket(1, inTrans), okPacket(0, ServerStatusAutocommit)},
+			[]int64{1, 1, 1}, TrxOutcomeCommit, 3},
+		{"rollback", []string{"select 1", "start transaction", "rollback"},
+			[][]byte{okPacket(0, ServerStatusAutocommit), okPacket(0, inTrans), okPacket(0, ServerStatusAutocommit)},
+			[]int64{0, 1, 1}, TrxOutcomeRollback, 2},
+		{"implicit commit", []string{"begin", "create table t2 (id int)"},
+			[][]byte{okPacket(0, inTrans), okPacket(0, ServerStatusAutocommit)},
+			[]int64{1, 1}, TrxOutcomeImplicitCommit, 2},
+		{"deadlock", []string{"begin", "update t set id = 2"},
+			[][]byte{okPacket(0, inTrans), errPacket(ErLockDeadlock)},
+			[]int64{1, 1}, TrxOutcomeDeadlock, 2},
+		// error in transaction without status keep the transaction
+		{"error in transaction", []string{"begin", "insert into t2 values (1)", "commit"},
+			[][]byte{okPacket(0, inTrans), errPacket(1146), okPacket(0, ServerStatusAutocommit)},
+			[]int64{1, 1, 1}, TrxOutcomeCommit, 3},
+	}
+
+	for _, c := range cases {
+		ts := newTestSession()
+		for i, statement := range c.statements {
+			ts.query(statement, c.responses[i])
+		}
+
+		stmtTrxIDs, summary := ts.trxPieces()
+		if !reflect.DeepEqual(stmtTrxIDs, c.stmtTrxIDs) {
+			t.Errorf("%s: got trx id of statements %v, want %v", c.name, stmtTrxIDs, c.stmtTrxIDs)
+		}
+		if summary == nil || summary.TrxID != 1 || *summary.QuerySQL != "transaction" ||
+			summary.Transaction.Outcome != c.outcome || summary.Transaction.Statements != c.trxStmts {
+			t.Errorf("%s: got summary %+v, want outcome %s of %d statements", c.name, summary, c.outcome, c.trxStmts)
+		}
+	}
+}
+
+func TestTransactionDisconnect(t *testing.T) {
+	ts := newTestSession()
+	ts.query("begin", okPacket(0, ServerStatusInTrans))
+	ts.query("update t set id = 1", okPacket(1, ServerStatusInTrans))
+	ts.Close()
+
+	_, summary := ts.trxPieces()
+	if summary == nil || summary.Transaction.Outcome != TrxOutcomeDisconnect || summary.Transaction.Statements != 2 {
+		t.Errorf("transaction should be finished on close, got %+v", summary)
+	}
+}
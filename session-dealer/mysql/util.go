@@ -5,6 +5,73 @@ import (
 	"encoding/binary"
 )
 
+// parseHandshakeV10 parse the initial handshake packet send by server
+// https://dev.mysql.com/doc/internals/en/connection-phase-packets.html#packet-Protocol::HandshakeV10
+func parseHandshakeV10(packet *handshakeV10, data []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = ErrMalformPacket
+		}
+	}()
+
+	offset := 0
+	packet.ProtocolVersion = data[offset]
+	offset++
+	if packet.ProtocolVersion != HandshakeProtocolVersion {
+		return ErrMalformPacket
+	}
+
+	packet.ServerVersion = string(data[offset : offset+bytes.IndexByte(data[offset:], 0)])
+	offset += len(packet.ServerVersion) + 1
+
+	packet.ConnectionID = binary.LittleEndian.Uint32(data[offset : offset+4])
+	offset += 4
+	// skip auth-plugin-data-part-1 and filler
+	offset += 8 + 1
+
+	packet.Capability = uint32(binary.LittleEndian.Uint16(data[offset : offset+2]))
+	offset += 2
+	if len(data[offset:]) == 0 {
+		return nil
+	}
+
+	packet.Collation = data[offset]
+	offset++
+	packet.Status = binary.LittleEndian.Uint16(data[offset : offset+2])
+	offset += 2
+	packet.Capability |= uint32(binary.LittleEndian.Uint16(data[offset:offset+2])) << 16
+	offset += 2
+
+	authDataLen := int(data[offset])
+	offset++
+	// skip reserved 10[00]
+	offset += 10
+
+	if packet.Capability&ClientSecureConnection > 0 {
+		partLen := authDataLen - 8
+		if partLen < 13 {
+			partLen = 13
+		}
+		offset += partLen
+	}
+
+	if packet.Capability&ClientPluginAuth > 0 && offset < len(data) {
+		idx := bytes.IndexByte(data[offset:], 0)
+		if idx < 0 {
+			idx = len(data[offset:])
+		}
+		packet.AuthPlugin = string(data[offset : offset+idx])
+	}
+
+	return nil
+}
+
+// IsGreeting check if mysql packet is the initial handshake send by server,
+// which is the only server packet with sequence id 0
+func IsGreeting(header []byte, firstByte byte) bool {
+	return header[3] == 0 && firstByte == HandshakeProtocolVersion
+}
+
 // parseHandshakeResponseHeader parses the common header of SSLRequest and HandshakeResponse41.
 func parseHandshakeResponseHeader(packet *handshakeResponse41, data []byte) (parsedBytes int, err error) {
 	// Ensure there are enough data to read:
@@ -21,7 +88,7 @@ func parseHandshakeResponseHeader(packet *handshakeResponse41, data []byte) (par
 	// skip max packet size
 	offset += 4
 	// charset, skip, if you want to use another charset, use set names
-	packet.Collation = data[offset]
+	packet.Collation = uint16(data[offset])
 	offset++
 	// skip reserved 23[00]
 	offset += 23
@@ -64,16 +131,16 @@ func parseHandshakeResponseBody(packet *handshakeResponse41, data []byte, offset
 
 	if packet.Capability&ClientConnectWithDB > 0 {
 		if len(data[offset:]) > 0 {
-			idx := bytes.IndexByte(data[offset:], 0)
-			packet.DBName = string(data[offset : offset+idx])
-			offset = offset + idx + 1
+			var n int
+			packet.DBName, n = readNulString(data[offset:])
+			offset += n
 		}
 	}
 
 	if packet.Capability&ClientPluginAuth > 0 {
-		// TODO: Support mysql.ClientPluginAuth, skip it now
-		idx := bytes.IndexByte(data[offset:], 0)
-		offset = offset + idx + 1
+		var n int
+		packet.AuthPlugin, n = readNulString(data[offset:])
+		offset += n
 	}
 
 	if packet.Capability&ClientConnectAtts > 0 {
@@ -81,11 +148,117 @@ func parseHandshakeResponseBody(packet *handshakeResponse41, data []byte, offset
 			// Defend some ill-formated packet, connection attribute is not important and can be ignored.
 			return nil
 		}
+		if packet.Attrs, err = parseConnAttrs(data[offset:]); err != nil {
+			// connection attribute is not important and can be ignored
+			packet.Attrs = nil
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// parseConnAttrs parse the key-value connection attributes in auth packet
+// https://dev.mysql.com/doc/refman/5.7/en/performance-schema-connection-attribute-tables.html
+func parseConnAttrs(data []byte) (attrs map[string]string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = ErrMalformPacket
+		}
+	}()
+
+	totalLen, _, offset := parseLengthEncodedInt(data)
+	end := offset + int(totalLen)
+	if end > len(data) {
+		return nil, ErrMalformPacket
+	}
+
+	attrs = make(map[string]string, 8)
+	for offset < end {
+		var key, val string
+		key, offset = readLengthEncodedString(data, offset)
+		val, offset = readLengthEncodedString(data, offset)
+		attrs[key] = val
+	}
+
+	return
+}
+
+// readLengthEncodedString read a length encoded string begin at offset, return string and next offset
+func readLengthEncodedString(data []byte, offset int) (str string, next int) {
+	num, isNull, n := parseLengthEncodedInt(data[offset:])
+	next = offset + n
+	if isNull {
+		return
+	}
+
+	str = string(data[next : next+int(num)])
+	next += int(num)
+	return
+}
+
+// parseChangeUser parse COM_CHANGE_USER packet without the command byte,
+// packet.Capability must be set to the capability of session
+// https://dev.mysql.com/doc/internals/en/com-change-user.html
+func parseChangeUser(packet *handshakeResponse41, data []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = ErrMalformPacket
+		}
+	}()
+
+	offset := 0
+	// user name
+	packet.User = string(data[offset : offset+bytes.IndexByte(data[offset:], 0)])
+	offset += len(packet.User) + 1
+
+	if packet.Capability&ClientSecureConnection > 0 {
+		authLen := int(data[offset])
+		offset++
+		packet.Auth = data[offset : offset+authLen]
+		offset += authLen
+	} else {
+		packet.Auth = data[offset : offset+bytes.IndexByte(data[offset:], 0)]
+		offset += len(packet.Auth) + 1
+	}
+
+	// schema name
+	var n int
+	packet.DBName, n = readNulString(data[offset:])
+	offset += n
+
+	// character set and the rest are optional
+	if len(data[offset:]) < 2 {
+		return nil
+	}
+	packet.Collation = binary.LittleEndian.Uint16(data[offset : offset+2])
+	offset += 2
+
+	if packet.Capability&ClientPluginAuth > 0 && len(data[offset:]) > 0 {
+		packet.AuthPlugin, n = readNulString(data[offset:])
+		offset += n
+	}
+
+	if packet.Capability&ClientConnectAtts > 0 && len(data[offset:]) > 0 {
+		if packet.Attrs, err = parseConnAttrs(data[offset:]); err != nil {
+			packet.Attrs = nil
+			return nil
+		}
 	}
 
 	return nil
 }
 
+// readNulString read string terminated by NUL, n is the bytes read including NUL,
+// string is the rest of data if NUL is missing
+func readNulString(data []byte) (str string, n int) {
+	idx := bytes.IndexByte(data, 0)
+	if idx < 0 {
+		return string(data), len(data)
+	}
+	return string(data[:idx]), idx + 1
+}
+
 func parseLengthEncodedInt(b []byte) (num uint64, isNull bool, n int) {
 	switch b[0] {
 	// 251: NULL
@@ -121,10 +294,33 @@ func parseLengthEncodedInt(b []byte) (num uint64, isNull bool, n int) {
 	return
 }
 
+// eachMysqlPacket call deal with every complete mysql packet payload in data, until deal return false
+func eachMysqlPacket(data []byte, deal func(payload []byte) bool) {
+	for len(data) > 4 {
+		payloadSize := extractMysqlPayloadSize(data[:4])
+		if len(data) < 4+payloadSize {
+			return
+		}
+
+		if !deal(data[4 : 4+payloadSize]) {
+			return
+		}
+		data = data[4+payloadSize:]
+	}
+}
+
 func extractMysqlPayloadSize(header []byte) int {
 	return int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
 }
 
 func bytesToInt(contents []byte) int {
 	return int(uint32(contents[0]) | uint32(contents[1])<<8 | uint32(contents[2])<<16 | uint32(contents[3])<<24)
-}
\ No newline at end of file
+}
+
+// minInt64 return the smaller one of a and b
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
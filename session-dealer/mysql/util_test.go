@@ -0,0 +1,170 @@
+package mysql
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestReadNulString(t *testing.T) {
+	cases := []struct {
+		data []byte
+		str  string
+		n    int
+	}{
+		{[]byte("db\x00rest"), "db", 3},
+		{[]byte("\x00"), "", 1},
+		{[]byte("mysql_native_password"), "mysql_native_password", 21},
+		{[]byte{}, "", 0},
+	}
+
+	for _, c := range cases {
+		if str, n := readNulString(c.data); str != c.str || n != c.n {
+			t.Errorf("read %q got %q %d, want %q %d", c.data, str, n, c.str, c.n)
+		}
+	}
+}
+
+func TestParseHandshakeResponseBody(t *testing.T) {
+	withDB := uint32(ClientSecureConnection | ClientConnectWithDB | ClientPluginAuth)
+	cases := []struct {
+		name       string
+		capability uint32
+		data       []byte
+		user       string
+		db         string
+		plugin     string
+		err        error
+	}{
+		{"db and plugin", withDB,
+			[]byte("root\x00\x02ab" + "test\x00" + "mysql_native_password\x00"), "root", "test", "mysql_native_password", nil},
+		{"plugin without nul", withDB,
+			[]byte("root\x00\x02ab" + "test\x00" + "caching_sha2_password"), "root", "test", "caching_sha2_password", nil},
+		{"db without nul", uint32(ClientSecureConnection | ClientConnectWithDB),
+			[]byte("root\x00\x00" + "test"), "root", "test", "", nil},
+		{"lenenc auth", uint32(ClientPluginAuthLenencClientData | ClientConnectWithDB),
+			[]byte("u\x00\x01x" + "d\x00"), "u", "d", "", nil},
+		{"truncated auth", withDB, []byte("root\x00\x09ab"), "root", "", "", ErrMalformPacket},
+		{"user without nul", withDB, []byte("root"), "", "", "", ErrMalformPacket},
+	}
+
+	for _, c := range cases {
+		packet := &handshakeResponse41{Capability: c.capability}
+		err := parseHandshakeResponseBody(packet, c.data, 0)
+		if err != c.err {
+			t.Errorf("%s: got error %v, want %v", c.name, err, c.err)
+			continue
+		}
+		if err == nil && (packet.User != c.user || packet.DBName != c.db || packet.AuthPlugin != c.plugin) {
+			t.Errorf("%s: got user %q db %q plugin %q, want %q %q %q",
+				c.name, packet.User, packet.DBName, packet.AuthPlugin, c.user, c.db, c.plugin)
+		}
+	}
+}
+
+func TestParseChangeUser(t *testing.T) {
+	capability := uint32(ClientSecureConnection | ClientPluginAuth)
+	cases := []struct {
+		name      string
+		data      []byte
+		user      string
+		db        string
+		collation uint16
+		plugin    string
+		err       error
+	}{
+		{"full packet", []byte("bob\x00\x01a" + "db1\x00" + "\x21\x00" + "mysql_native_password\x00"),
+			"bob", "db1", 33, "mysql_native_password", nil},
+		{"no charset", []byte("bob\x00\x00" + "db1\x00"), "bob", "db1", 0, "", nil},
+		{"plugin without nul", []byte("bob\x00\x01a" + "db1\x00" + "\x1c\x00" + "sha256_password"),
+			"bob", "db1", 28, "sha256_password", nil},
+		{"db without nul", []byte("bob\x00\x00" + "db1"), "bob", "db1", 0, "", nil},
+		{"no db", []byte("bob\x00\x00"), "bob", "", 0, "", nil},
+		{"truncated auth", []byte("bob\x00\x05a"), "", "", 0, "", ErrMalformPacket},
+		{"user without nul", []byte("bob"), "", "", 0, "", ErrMalformPacket},
+	}
+
+	for _, c := range cases {
+		packet := &handshakeResponse41{Capability: capability}
+		err := parseChangeUser(packet, c.data)
+		if err != c.err {
+			t.Errorf("%s: got error %v, want %v", c.name, err, c.err)
+			continue
+		}
+		if err == nil && (packet.User != c.user || packet.DBName != c.db ||
+			packet.Collation != c.collation || packet.AuthPlugin != c.plugin) {
+			t.Errorf("%s: got user %q db %q collation %d plugin %q, want %q %q %d %q", c.name,
+				packet.User, packet.DBName, packet.Collation, packet.AuthPlugin, c.user, c.db, c.collation, c.plugin)
+		}
+	}
+}
+
+func TestIsGreeting(t *testing.T) {
+	cases := []struct {
+		header    []byte
+		firstByte byte
+		greeting  bool
+	}{
+		{[]byte{0x4a, 0, 0, 0}, HandshakeProtocolVersion, true},
+		{[]byte{0x0b, 0, 0, 1}, HandshakeProtocolVersion, false},
+		{[]byte{0x07, 0, 0, 0}, OKHeader, false},
+	}
+
+	for _, c := range cases {
+		if greeting := IsGreeting(c.header, c.firstByte); greeting != c.greeting {
+			t.Errorf("header % x first byte %#x got greeting %v, want %v", c.header, c.firstByte, greeting, c.greeting)
+		}
+	}
+}
+
+// connAttrsData compose length encoded key-value pairs of connection attributes
+func connAttrsData(pairs ...string) []byte {
+	var content []byte
+	for _, item := range pairs {
+		content = append(content, byte(len(item)))
+		content = append(content, item...)
+	}
+	return append([]byte{byte(len(content))}, content...)
+}
+
+func TestParseConnAttrs(t *testing.T) {
+	cases := []struct {
+		name  string
+		data  []byte
+		attrs map[string]string
+		err   error
+	}{
+		{"attrs", connAttrsData("_client_name", "libmysql", "program_name", "order-service"),
+			map[string]string{"_client_name": "libmysql", "program_name": "order-service"}, nil},
+		{"empty value", connAttrsData("_pid", ""), map[string]string{"_pid": ""}, nil},
+		{"no attr", []byte{0}, map[string]string{}, nil},
+		{"total length too long", []byte{0x20, 1, 'a', 1, 'b'}, nil, ErrMalformPacket},
+		{"value out of range", []byte{0x04, 1, 'a', 9, 'b'}, nil, ErrMalformPacket},
+	}
+
+	for _, c := range cases {
+		attrs, err := parseConnAttrs(c.data)
+		if err != c.err {
+			t.Errorf("%s: got error %v, want %v", c.name, err, c.err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(attrs, c.attrs) {
+			t.Errorf("%s: got attrs %v, want %v", c.name, attrs, c.attrs)
+		}
+	}
+}
+
+func TestEachMysqlPacket(t *testing.T) {
+	stream := mysqlPackets([]byte("a"), []byte{}, []byte("bc"))
+	var payloads [][]byte
+	eachMysqlPacket(append(stream, 9, 0), func(payload []byte) bool {
+		payloads = append(payloads, payload)
+		return true
+	})
+
+	// the incomplete packet in tail is not dealt
+	if len(payloads) != 3 || !bytes.Equal(payloads[0], []byte("a")) ||
+		len(payloads[1]) != 0 || !bytes.Equal(payloads[2], []byte("bc")) {
+		t.Errorf("got payloads %q", payloads)
+	}
+}